@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"simulator/src/broker"
+	mqtt "simulator/src/core"
+)
+
+// embeddedTLS genera en un directorio temporal la CA del broker embebido y, si la
+// configuración pide certificado de cliente, uno firmado por ella para cada device
+// (o uno común). Las rutas de broker.tls pasan a apuntar a esos archivos, y con
+// certificados de cliente el broker rechaza a quien no presente uno firmado por la CA.
+func embeddedTLS(cfg *mqtt.Config) (*tls.Config, string, error) {
+	ca, err := broker.NewCA()
+	if err != nil {
+		return nil, "", err
+	}
+	t := &cfg.Broker.TLS
+	serverCfg, err := ca.ServerTLS(t.CertFile != "", "127.0.0.1", "localhost")
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := os.MkdirTemp("", "simulador-tls-")
+	if err != nil {
+		return nil, "", fmt.Errorf("broker embebido: %w", err)
+	}
+
+	t.CAFile = filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(t.CAFile, ca.PEM, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("broker embebido: %w", err)
+	}
+	if t.CertFile != "" {
+		t.CertFile = inDir(dir, t.CertFile)
+		t.KeyFile = inDir(dir, t.KeyFile)
+		if err := issueClientCerts(cfg, ca); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+	}
+	log.Println("Certificados TLS de prueba en", dir)
+	return serverCfg, dir, nil
+}
+
+// inDir sitúa path dentro de dir conservando sus directorios (certs/{device_id}/cert.pem),
+// sin que una ruta absoluta o con .. se salga de dir
+func inDir(dir, path string) string {
+	path = strings.TrimPrefix(path, filepath.VolumeName(path))
+	return filepath.Join(dir, filepath.Join(string(filepath.Separator), path))
+}
+
+// issueClientCerts escribe los certificados de cliente que espera broker.tls
+func issueClientCerts(cfg *mqtt.Config, ca *broker.CA) error {
+	t := cfg.Broker.TLS
+	issue := func(name, certFile, keyFile string) error {
+		certPEM, keyPEM, err := ca.Issue(name)
+		if err != nil {
+			return fmt.Errorf("broker embebido: %w", err)
+		}
+		for _, f := range []string{certFile, keyFile} {
+			if err := os.MkdirAll(filepath.Dir(f), 0o700); err != nil {
+				return fmt.Errorf("broker embebido: %w", err)
+			}
+		}
+		if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+			return fmt.Errorf("broker embebido: %w", err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			return fmt.Errorf("broker embebido: %w", err)
+		}
+		return nil
+	}
+
+	if !strings.Contains(t.CertFile, "{") && !strings.Contains(t.KeyFile, "{") {
+		return issue(cfg.Broker.ClientID, t.CertFile, t.KeyFile)
+	}
+	// la conexión general usa el certificado del device 0
+	general := strings.NewReplacer("{device_id}", "0", "{user_id}", "0")
+	if err := issue(cfg.Broker.ClientID, general.Replace(t.CertFile), general.Replace(t.KeyFile)); err != nil {
+		return err
+	}
+	for _, g := range cfg.Fleet {
+		for id := g.FirstID; id <= g.LastID; id++ {
+			// mismas variables que las plantillas de core
+			r := strings.NewReplacer("{device_id}", strconv.Itoa(id), "{user_id}", strconv.Itoa(id+g.UserOffset))
+			name := cfg.Broker.ClientID + "-" + strconv.Itoa(id)
+			if err := issue(name, r.Replace(t.CertFile), r.Replace(t.KeyFile)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
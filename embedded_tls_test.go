@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mqtt "simulator/src/core"
+)
+
+func TestEmbeddedTLSPerDeviceCerts(t *testing.T) {
+	cfg := mqtt.DefaultConfig()
+	cfg.Broker.ClientID = "sim"
+	cfg.Broker.TLS.CertFile = "certs/{device_id}/cert.pem"
+	cfg.Broker.TLS.KeyFile = "certs/{device_id}/key.pem"
+	cfg.Fleet = []mqtt.DeviceGroup{{FirstID: 1, LastID: 2}}
+
+	server, dir, err := embeddedTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if server.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("ClientAuth %v, se esperaba RequireAndVerifyClientCert", server.ClientAuth)
+	}
+	if want := filepath.Join(dir, "certs", "{device_id}", "cert.pem"); cfg.Broker.TLS.CertFile != want {
+		t.Fatalf("cert_file %s, se esperaba %s", cfg.Broker.TLS.CertFile, want)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		for _, name := range []string{"cert.pem", "key.pem"} {
+			if _, err := os.Stat(filepath.Join(dir, "certs", id, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, "certs", "1", "cert.pem"), filepath.Join(dir, "certs", "1", "key.pem")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbeddedTLSWithoutClientCerts(t *testing.T) {
+	cfg := mqtt.DefaultConfig()
+	server, dir, err := embeddedTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if server.ClientAuth == tls.RequireAndVerifyClientCert {
+		t.Fatal("se exigió certificado de cliente sin cert_file")
+	}
+}
+
+func TestInDirKeepsRelativePath(t *testing.T) {
+	dir := filepath.Join("tmp", "tls")
+	cases := map[string]string{
+		"cert.pem":                   filepath.Join(dir, "cert.pem"),
+		"certs/{device_id}/cert.pem": filepath.Join(dir, "certs", "{device_id}", "cert.pem"),
+		"../../fuera/cert.pem":       filepath.Join(dir, "fuera", "cert.pem"),
+		"/etc/certs/cert.pem":        filepath.Join(dir, "etc", "certs", "cert.pem"),
+	}
+	for path, want := range cases {
+		if got := inDir(dir, path); got != want {
+			t.Errorf("inDir(%q) = %s, se esperaba %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !nogui
+
+package main
+
+import (
+	"log"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	mqtt "simulator/src/core"
+	"simulator/src/gui"
+)
+
+// runGUI abre la ventana de Ebiten sobre el mismo core que usa el modo headless
+func runGUI(cfg *mqtt.Config) error {
+	game := gui.StartUI(cfg)
+	if cfg.UsesSink(mqtt.SinkMQTT) {
+		if err := mqtt.ConnectMqtt(cfg); err != nil {
+			// la ventana sigue disponible; iniciar la simulación informará el error
+			log.Println(err)
+		}
+	}
+
+	ebiten.SetWindowSize(640, 480)
+	ebiten.SetWindowTitle("Simulador WarmHeart IoT")
+
+	return ebiten.RunGame(game)
+}
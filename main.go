@@ -1,26 +1,222 @@
 package main
 
 import (
-
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
   mqtt "simulator/src/core"
-	"github.com/hajimehoshi/ebiten/v2"
-	"simulator/src/gui"
+	"simulator/src/broker"
+	"simulator/src/headless"
 	
 )
 
 func main() {
-	game := gui.StartUI()
-  	mqtt.ConnectMqtt()
-	
+	configPath := flag.String("config", "", "archivo JSON con la configuración de la simulación")
+	scenarioPath := flag.String("scenario", "", "archivo JSON con eventos clínicos programados")
+	sinks := flag.String("sinks", "", "sinks separados por comas: mqtt, amqp, http, stdout, file")
+	headlessMode := flag.Bool("headless", false, "ejecutar sin ventana (servidores y CI)")
+	devices := flag.Int("devices", 0, "número de dispositivos (reemplaza la flota de la configuración)")
+	interval := flag.Duration("interval", 0, "intervalo entre lecturas de cada dispositivo")
+	duration := flag.Duration("duration", 0, "duración de la simulación (0 = hasta Ctrl+C)")
+	statsEvery := flag.Duration("stats", 5*time.Second, "cada cuánto imprimir estadísticas en modo headless")
+	embeddedBroker := flag.Bool("embedded-broker", false, "levantar un broker MQTT en proceso (sin red ni RabbitMQ)")
+	verbose := flag.Bool("verbose", false, "imprimir cada mensaje publicado en modo headless")
+	capture := flag.String("capture", "", "archivo donde guardar cada mensaje publicado (.ndjson o .csv)")
+	replay := flag.String("replay", "", "captura a reproducir en lugar de simular la flota")
+	speed := flag.Float64("speed", 1, "velocidad de la reproducción (10 = diez veces más rápido, 0 = sin esperas)")
+	clockSpeed := flag.Float64("clock-speed", 0, "acelerar el tiempo simulado (3600 = una hora por segundo)")
+	clockStep := flag.Duration("clock-step", 0, "reloj manual que avanza este paso tan rápido como se publique")
+	seed := flag.Int64("seed", 0, "semilla de la simulación para repetir una ejecución (0 = nueva)")
+	start := flag.String("start", "", "instante simulado inicial en RFC3339 (el que registra la semilla al arrancar)")
+	flag.Parse()
 
-    
-	
-	ebiten.SetWindowSize(640, 480)
-	ebiten.SetWindowTitle("Simulador WarmHeart IoT")
+	mqtt.LoadEnv()
+	var cfg *mqtt.Config
+	if *configPath != "" {
+		loaded, err := mqtt.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg = loaded
+	} else {
+		cfg = mqtt.DefaultConfig()
+	}
+	if *scenarioPath != "" {
+		scenario, err := mqtt.LoadScenario(*scenarioPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Scenario = scenario
+	}
+	if *sinks != "" {
+		cfg.Sinks = strings.Split(*sinks, ",")
+	}
+	if *devices > 0 {
+		cfg.Fleet = []mqtt.DeviceGroup{{
+			FirstID:  2,
+			LastID:   *devices + 1,
+			Interval: cfg.Fleet[0].Interval,
+			Profile:  cfg.Fleet[0].Profile,
+		}}
+	}
+	if *interval > 0 {
+		for i := range cfg.Fleet {
+			cfg.Fleet[i].Interval = mqtt.Duration(*interval)
+		}
+	}
+	if *duration > 0 {
+		cfg.Duration = mqtt.Duration(*duration)
+	}
+	if *clockSpeed > 0 {
+		cfg.Clock.Mode = mqtt.ClockScaled
+		cfg.Clock.Speed = *clockSpeed
+	}
+	if *clockStep > 0 {
+		cfg.Clock.Mode = mqtt.ClockManual
+		cfg.Clock.Step = mqtt.Duration(*clockStep)
+	}
+	if *seed != 0 {
+		cfg.Seed = *seed
+	}
+	if *start != "" {
+		t, err := time.Parse(time.RFC3339, *start)
+		if err != nil {
+			log.Fatalf("-start no válido: %v", err)
+		}
+		cfg.Clock.Start = t
+	}
+	if *replay != "" {
+		cfg.Replay.File = *replay
+	}
+	if flagPassed("speed") {
+		cfg.Replay.Speed = *speed
+	}
+	if *capture != "" {
+		cfg.Capture.Path = *capture
+		if strings.EqualFold(filepath.Ext(*capture), ".csv") {
+			cfg.Capture.Format = mqtt.CaptureCSV
+		}
+	}
+	var recorder *broker.Recorder
+	if *embeddedBroker {
+		cfg.Broker.Embedded = true
+	}
+	if cfg.Broker.Embedded {
+		stopBroker, rec, err := startEmbeddedBroker(cfg, *headlessMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stopBroker()
+		recorder = rec
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("configuración inválida:\n%v", err)
+	}
+
+	if *headlessMode {
+		mqtt.LogMessages = *verbose
+		if err := headless.Run(cfg, *statsEvery); err != nil {
+			log.Fatal(err)
+		}
+		if recorder != nil {
+			// el broker puede no haber leído aún los últimos paquetes QoS 0
+			recorder.WaitFor(int(mqtt.GetStats().Published), time.Second)
+			log.Printf("Broker embebido: %d mensajes recibidos", recorder.Count())
+		}
+		return
+	}
 
-	if err := ebiten.RunGame(game); err != nil {
+	if err := runGUI(cfg); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// startEmbeddedBroker levanta el broker en proceso y apunta la configuración hacia él.
+// Con una URL ssl://, mqtts:// o wss:// escucha con TLS firmado por una CA efímera;
+// con ws:// o wss:// atiende MQTT sobre WebSockets. Con record devuelve un Recorder
+// para contar lo recibido (sólo lo consulta el modo headless al terminar).
+func startEmbeddedBroker(cfg *mqtt.Config, record bool) (func(), *broker.Recorder, error) {
+	scheme, addr, found := strings.Cut(cfg.Broker.Host, "://")
+	if !found {
+		scheme, addr = "tcp", cfg.Broker.Host
+	}
+	addr, path, hasPath := strings.Cut(addr, "/")
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	if cfg.Broker.ClientID == "" {
+		cfg.Broker.ClientID = "simulador"
+	}
+
+	embedded := broker.New()
+	stop := func() { embedded.Close() }
+	var bound string
+	var err error
+	switch scheme {
+	case "tcp", "mqtt":
+		bound, err = embedded.ListenAndServe(addr)
+	case "ssl", "tls", "mqtts", "wss":
+		var tlsCfg *tls.Config
+		var dir string
+		if tlsCfg, dir, err = embeddedTLS(cfg); err != nil {
+			return nil, nil, err
+		}
+		stop = func() {
+			embedded.Close()
+			os.RemoveAll(dir)
+		}
+		if scheme == "wss" {
+			bound, err = embedded.ListenAndServeWebSocket(addr, wsPath(cfg, path, hasPath), tlsCfg)
+		} else {
+			bound, err = embedded.ListenAndServeTLS(addr, tlsCfg)
+		}
+	case "ws":
+		bound, err = embedded.ListenAndServeWebSocket(addr, wsPath(cfg, path, hasPath), nil)
+	default:
+		return nil, nil, fmt.Errorf("el broker embebido no admite URLs %s://", scheme)
+	}
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	log.Printf("Broker MQTT embebido escuchando en %s://%s", scheme, bound)
+
+	cfg.Broker.Host = scheme + "://" + bound
+	if hasPath {
+		cfg.Broker.Host += "/" + path
+	}
+	if cfg.Topics.Publish == "" {
+		cfg.Topics.Publish = "esp32.datos"
+	}
+	if !record {
+		return stop, nil, nil
+	}
+	// sólo interesa el recuento final de los datos (sin estados ni otras lecturas por sensor),
+	// comparable con los mensajes publicados: se guarda únicamente el último
+	return stop, embedded.Record(cfg.Topics.DataFilter(), 1), nil
+}
+
+// wsPath es la ruta del endpoint WebSocket: la de la URL o la de broker.websocket.path
+func wsPath(cfg *mqtt.Config, path string, hasPath bool) string {
+	if hasPath {
+		return "/" + path
+	}
+	return cfg.Broker.WebSocket.Path
+}
+
+// flagPassed indica si el flag se indicó en la línea de comandos
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
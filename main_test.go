@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "simulator/src/core"
+)
+
+func TestEmbeddedBrokerReceivesPublished(t *testing.T) {
+	cfg := mqtt.DefaultConfig()
+	cfg.Sinks = []string{mqtt.SinkMQTT}
+	cfg.Broker.Host = "127.0.0.1:0"
+	cfg.Topics.Publish = "sensores/{device_id}"
+	cfg.Topics.PerSensor = true
+	cfg.Topics.QoS = 1
+	cfg.Fleet = []mqtt.DeviceGroup{{FirstID: 1, LastID: 3, Interval: mqtt.Duration(time.Minute), Profile: mqtt.DefaultProfile}}
+	cfg.Duration = mqtt.Duration(10 * time.Minute)
+	cfg.Seed = 42
+	cfg.Clock = mqtt.ClockConfig{Mode: mqtt.ClockManual, Step: mqtt.Duration(time.Minute)}
+
+	stop, recorder, err := startEmbeddedBroker(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mqtt.ConnectMqtt(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer mqtt.DisconnectMqtt()
+
+	if err := mqtt.StartSimulation(cfg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-mqtt.SimulationDone():
+	case <-time.After(10 * time.Second):
+		mqtt.StopSimulation()
+		t.Fatal("la simulación no terminó")
+	}
+
+	// cada mensaje sale como cinco lecturas; el recorder cuenta una por mensaje
+	stats := mqtt.GetStats()
+	if stats.Published != 3*9 || stats.Failed != 0 {
+		t.Fatalf("publicados %d y fallidos %d, se esperaban %d y 0", stats.Published, stats.Failed, 3*9)
+	}
+	recorder.WaitFor(int(stats.Published), time.Second)
+	if got := recorder.Count(); got != int(stats.Published) {
+		t.Fatalf("el broker recibió %d mensajes de datos, se publicaron %d", got, stats.Published)
+	}
+	if msgs := recorder.Messages(); len(msgs) != 1 || !strings.HasSuffix(msgs[0].Topic, "/motion") {
+		t.Fatalf("último mensaje grabado %+v, se esperaba una lectura de motion", msgs)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build nogui
+
+package main
+
+import (
+	"errors"
+
+	mqtt "simulator/src/core"
+)
+
+// runGUI no está disponible en binarios compilados con -tags nogui
+func runGUI(cfg *mqtt.Config) error {
+	return errors.New("binario compilado sin GUI: use -headless")
+}
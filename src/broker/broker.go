@@ -0,0 +1,269 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Broker es un broker MQTT 3.1.1 mínimo y en memoria para ejecuciones locales y CI.
+// Admite QoS 0, 1 y 2, mensajes retenidos y Last Will sobre TCP, TLS o WebSockets; no persiste sesiones
+// (todas se tratan como clean session) ni retransmite entregas sin confirmar.
+type Broker struct {
+	// Auth valida usuario y contraseña; nil acepta a cualquier cliente
+	Auth func(username, password string) bool
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	clients   map[string]*conn
+	subs      map[subscriber]map[string]byte // suscriptor -> filtro -> QoS
+	retained  map[string]Message
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Message es una publicación que pasó por el broker
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+	Time    time.Time
+}
+
+// subscriber recibe los mensajes que coinciden con sus filtros
+type subscriber interface {
+	deliver(m Message, qos byte)
+}
+
+// New crea un broker sin listeners
+func New() *Broker {
+	return &Broker{
+		clients:  make(map[string]*conn),
+		subs:     make(map[subscriber]map[string]byte),
+		retained: make(map[string]Message),
+	}
+}
+
+// ListenAndServe escucha TCP en addr ("127.0.0.1:0" elige un puerto libre)
+// y devuelve la dirección real en la que quedó escuchando.
+func (b *Broker) ListenAndServe(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("broker embebido: %w", err)
+	}
+	go b.Serve(ln)
+	return ln.Addr().String(), nil
+}
+
+// Serve acepta conexiones MQTT en ln hasta que se cierre el broker
+func (b *Broker) Serve(ln net.Listener) error {
+	if !b.addListener(ln) {
+		return net.ErrClosed
+	}
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go b.serveConn(nc)
+	}
+}
+
+// addListener registra ln para cerrarlo con el broker; si ya está cerrado lo cierra
+func (b *Broker) addListener(ln net.Listener) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		ln.Close()
+		return false
+	}
+	b.listeners = append(b.listeners, ln)
+	return true
+}
+
+// serveConn atiende una conexión ya aceptada hasta que se cierra
+func (b *Broker) serveConn(nc net.Conn) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		nc.Close()
+		return
+	}
+	b.wg.Add(1)
+	b.mu.Unlock()
+	defer b.wg.Done()
+	newConn(b, nc).serve()
+}
+
+// Close detiene los listeners y desconecta a todos los clientes
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	listeners := b.listeners
+	clients := make([]*conn, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	for _, c := range clients {
+		c.close()
+	}
+	b.wg.Wait()
+	return nil
+}
+
+// Publish inyecta un mensaje como si lo hubiera publicado un cliente
+func (b *Broker) Publish(m Message) {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	b.route(m)
+}
+
+// route guarda los retenidos y entrega a cada suscriptor con el menor QoS entre ambos
+func (b *Broker) route(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	type target struct {
+		s   subscriber
+		qos byte
+	}
+	var targets []target
+	for s, filters := range b.subs {
+		granted, matched := byte(0), false
+		for filter, qos := range filters {
+			if matchTopic(filter, m.Topic) {
+				matched = true
+				granted = max(granted, qos)
+			}
+		}
+		if matched {
+			targets = append(targets, target{s, min(granted, m.QoS)})
+		}
+	}
+	b.mu.Unlock()
+
+	// las entregas normales no llevan el flag retain
+	m.Retain = false
+	for _, t := range targets {
+		t.s.deliver(m, t.qos)
+	}
+}
+
+// subscribe registra el filtro y devuelve los retenidos que coinciden
+func (b *Broker) subscribe(s subscriber, filter string, qos byte) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[s] == nil {
+		b.subs[s] = make(map[string]byte)
+	}
+	b.subs[s][filter] = qos
+
+	var retained []Message
+	for topic, m := range b.retained {
+		if matchTopic(filter, topic) {
+			retained = append(retained, m)
+		}
+	}
+	return retained
+}
+
+func (b *Broker) unsubscribe(s subscriber, filter string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs[s], filter)
+}
+
+func (b *Broker) removeSubscriber(s subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, s)
+}
+
+// register asocia el client ID a la conexión y expulsa a una sesión anterior con el mismo ID
+func (b *Broker) register(c *conn) bool {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+	old := b.clients[c.id]
+	b.clients[c.id] = c
+	b.mu.Unlock()
+
+	if old != nil {
+		old.takeOver()
+	}
+	return true
+}
+
+func (b *Broker) unregister(c *conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clients[c.id] == c {
+		delete(b.clients, c.id)
+	}
+}
+
+// ClientCount devuelve el número de clientes conectados
+func (b *Broker) ClientCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// validFilter comprueba el uso de los comodines + y #
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTopic aplica un filtro con comodines a un tópico concreto
+func matchTopic(filter, topic string) bool {
+	// los comodines iniciales no coinciden con tópicos de sistema ($SYS...)
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
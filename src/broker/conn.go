@@ -0,0 +1,268 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// tiempo máximo para recibir el CONNECT tras abrir el socket
+const connectTimeout = 10 * time.Second
+
+// conn es la sesión de un cliente MQTT conectado
+type conn struct {
+	b  *Broker
+	nc net.Conn
+	r  *bufio.Reader
+
+	id        string
+	keepAlive time.Duration
+
+	mu     sync.Mutex // protege will, nextID y las escrituras en nc
+	will   *Message
+	nextID uint16
+	closed bool
+}
+
+func newConn(b *Broker, nc net.Conn) *conn {
+	return &conn{b: b, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// serve atiende al cliente hasta que se desconecta
+func (c *conn) serve() {
+	defer c.nc.Close()
+
+	c.nc.SetReadDeadline(time.Now().Add(connectTimeout))
+	first, err := readPacket(c.r)
+	if err != nil || first.kind != packetConnect {
+		return
+	}
+	if !c.handleConnect(first) {
+		return
+	}
+	defer c.finish()
+
+	for {
+		if c.keepAlive > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		} else {
+			c.nc.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(c.r)
+		if err != nil {
+			return
+		}
+		if err := c.handle(p); err != nil {
+			log.Printf("broker embebido: cliente %s: %v", c.id, err)
+			return
+		}
+		if p.kind == packetDisconnect {
+			return
+		}
+	}
+}
+
+// handleConnect valida el CONNECT, responde con CONNACK y registra la sesión
+func (c *conn) handleConnect(p packet) bool {
+	r := &reader{buf: p.body}
+	protocol := r.string()
+	level := r.byte()
+	flags := r.byte()
+	keepAlive := r.uint16()
+	clientID := r.string()
+
+	var will *Message
+	if flags&0x04 != 0 {
+		topic := r.string()
+		payload := append([]byte(nil), r.bytes()...)
+		will = &Message{Topic: topic, Payload: payload, QoS: (flags >> 3) & 0x03, Retain: flags&0x20 != 0}
+	}
+	var username, password string
+	if flags&0x80 != 0 {
+		username = r.string()
+	}
+	if flags&0x40 != 0 {
+		password = r.string()
+	}
+	if r.err != nil {
+		return false
+	}
+
+	if !(protocol == "MQTT" && level == 4) && !(protocol == "MQIsdp" && level == 3) {
+		c.write(packetConnack, 0, []byte{0, connBadProtocol})
+		return false
+	}
+	if clientID == "" {
+		if flags&0x02 == 0 {
+			c.write(packetConnack, 0, []byte{0, connIdentifierRejected})
+			return false
+		}
+		clientID = fmt.Sprintf("auto-%p", c)
+	}
+	if c.b.Auth != nil && !c.b.Auth(username, password) {
+		c.write(packetConnack, 0, []byte{0, connBadCredentials})
+		return false
+	}
+
+	c.id = clientID
+	c.will = will
+	c.keepAlive = time.Duration(keepAlive) * time.Second
+	if !c.b.register(c) {
+		return false
+	}
+	return c.write(packetConnack, 0, []byte{0, connAccepted}) == nil
+}
+
+// handle procesa un paquete de una sesión ya establecida
+func (c *conn) handle(p packet) error {
+	r := &reader{buf: p.body}
+	switch p.kind {
+	case packetPublish:
+		qos := (p.flags >> 1) & 0x03
+		if qos > 2 {
+			return errMalformed
+		}
+		topic := r.string()
+		var id uint16
+		if qos > 0 {
+			id = r.uint16()
+		}
+		payload := append([]byte(nil), r.rest()...)
+		if r.err != nil {
+			return r.err
+		}
+		c.b.route(Message{Topic: topic, Payload: payload, QoS: qos, Retain: p.flags&0x01 != 0, Time: time.Now()})
+		switch qos {
+		case 1:
+			return c.write(packetPuback, 0, appendUint16(nil, id))
+		case 2:
+			return c.write(packetPubrec, 0, appendUint16(nil, id))
+		}
+	case packetPubrel:
+		return c.write(packetPubcomp, 0, appendUint16(nil, r.uint16()))
+	case packetPubrec:
+		// segunda fase de una entrega QoS 2 hacia el cliente
+		return c.write(packetPubrel, 0x02, appendUint16(nil, r.uint16()))
+	case packetPuback, packetPubcomp:
+		// sin retransmisiones: no hay nada pendiente que liberar
+	case packetSubscribe:
+		id := r.uint16()
+		var codes []byte
+		var retained []Message
+		var granted []byte
+		for len(r.buf) > 0 && r.err == nil {
+			filter := r.string()
+			qos := r.byte()
+			if r.err != nil {
+				break
+			}
+			if qos > 2 || !validFilter(filter) {
+				codes = append(codes, 0x80)
+				continue
+			}
+			codes = append(codes, qos)
+			for _, m := range c.b.subscribe(c, filter, qos) {
+				retained = append(retained, m)
+				granted = append(granted, qos)
+			}
+		}
+		if r.err != nil || len(codes) == 0 {
+			return errMalformed
+		}
+		if err := c.write(packetSuback, 0, append(appendUint16(nil, id), codes...)); err != nil {
+			return err
+		}
+		for i, m := range retained {
+			c.send(m, min(granted[i], m.QoS), true)
+		}
+	case packetUnsubscribe:
+		id := r.uint16()
+		for len(r.buf) > 0 && r.err == nil {
+			c.b.unsubscribe(c, r.string())
+		}
+		if r.err != nil {
+			return r.err
+		}
+		return c.write(packetUnsuback, 0, appendUint16(nil, id))
+	case packetPingreq:
+		return c.write(packetPingresp, 0, nil)
+	case packetDisconnect:
+		// desconexión limpia: el Last Will se descarta
+		c.mu.Lock()
+		c.will = nil
+		c.mu.Unlock()
+	default:
+		return fmt.Errorf("paquete inesperado de tipo %d", p.kind)
+	}
+	return nil
+}
+
+// deliver implementa subscriber para los clientes de red
+func (c *conn) deliver(m Message, qos byte) {
+	c.send(m, qos, false)
+}
+
+// send escribe un PUBLISH hacia el cliente
+func (c *conn) send(m Message, qos byte, retain bool) {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	if qos > 0 {
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id := c.nextID
+		c.mu.Unlock()
+		body = appendUint16(body, id)
+	}
+	body = append(body, m.Payload...)
+	c.write(packetPublish, flags, body)
+}
+
+func (c *conn) write(kind, flags byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.nc.SetWriteDeadline(time.Now().Add(connectTimeout))
+	_, err := c.nc.Write(encodePacket(kind, flags, body))
+	return err
+}
+
+// finish libera la sesión y publica el Last Will si la desconexión no fue limpia
+func (c *conn) finish() {
+	c.b.removeSubscriber(c)
+	c.b.unregister(c)
+
+	c.mu.Lock()
+	will := c.will
+	c.will = nil
+	c.closed = true
+	c.mu.Unlock()
+
+	if will != nil {
+		will.Time = time.Now()
+		c.b.route(*will)
+	}
+}
+
+// takeOver cierra la sesión porque otro cliente se conectó con el mismo ID
+func (c *conn) takeOver() {
+	c.mu.Lock()
+	c.will = nil
+	c.mu.Unlock()
+	c.nc.Close()
+}
+
+// close corta la conexión de red; el Last Will se publica como en una caída
+func (c *conn) close() {
+	c.nc.Close()
+}
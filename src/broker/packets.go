@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// tipos de paquete MQTT 3.1.1
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// códigos de retorno de CONNACK
+const (
+	connAccepted           byte = 0
+	connBadProtocol        byte = 1
+	connIdentifierRejected byte = 2
+	connBadCredentials     byte = 4
+)
+
+// tamaño máximo aceptado para un paquete (el protocolo admite hasta 256MB)
+const maxPacketSize = 1 << 20
+
+var errMalformed = errors.New("paquete MQTT mal formado")
+
+// packet es un paquete de control ya separado en cabecera y cuerpo
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket lee un paquete completo del stream
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("paquete de %d bytes supera el máximo de %d", length, maxPacketSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// encodePacket arma la cabecera fija y concatena el cuerpo
+func encodePacket(kind, flags byte, body []byte) []byte {
+	out := make([]byte, 0, len(body)+5)
+	out = append(out, kind<<4|flags)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// reader recorre el cuerpo de un paquete
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.buf) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) rest() []byte {
+	b := r.buf
+	r.buf = nil
+	return b
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+// Recorder es un suscriptor interno que cuenta lo que coincide con su filtro y guarda
+// los últimos mensajes
+type Recorder struct {
+	mu      sync.Mutex
+	msgs    []Message // anillo con los últimos limit mensajes
+	next    int       // posición del más antiguo cuando el anillo está lleno
+	limit   int
+	count   int
+	changed chan struct{}
+}
+
+// Record suscribe un Recorder al filtro indicado (por ejemplo "#") que guarda como mucho
+// los últimos limit mensajes; 0 = todos
+func (b *Broker) Record(filter string, limit int) *Recorder {
+	r := &Recorder{limit: limit, changed: make(chan struct{})}
+	for _, m := range b.subscribe(r, filter, 2) {
+		r.deliver(m, m.QoS)
+	}
+	return r
+}
+
+func (r *Recorder) deliver(m Message, qos byte) {
+	m.QoS = qos
+	r.mu.Lock()
+	r.count++
+	if r.limit > 0 && len(r.msgs) == r.limit {
+		r.msgs[r.next] = m
+		r.next = (r.next + 1) % r.limit
+	} else {
+		r.msgs = append(r.msgs, m)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// Messages devuelve una copia de los mensajes guardados, del más antiguo al más reciente
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(append([]Message(nil), r.msgs[r.next:]...), r.msgs[:r.next]...)
+}
+
+// Count devuelve cuántos mensajes se recibieron, incluidos los que ya salieron del anillo
+func (r *Recorder) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+// WaitFor espera hasta tener al menos n mensajes; devuelve false si vence el plazo
+func (r *Recorder) WaitFor(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		count, changed := r.count, r.changed
+		r.mu.Unlock()
+		if count >= n {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecorderKeepsLastMessages(t *testing.T) {
+	b := New()
+	rec := b.Record("sensores/#", 3)
+	for i := 1; i <= 5; i++ {
+		b.Publish(Message{Topic: fmt.Sprintf("sensores/%d", i), Payload: []byte{byte(i)}, QoS: 1})
+	}
+	b.Publish(Message{Topic: "otros/1", Payload: []byte{9}})
+
+	if got := rec.Count(); got != 5 {
+		t.Fatalf("Count %d, se esperaban 5", got)
+	}
+	msgs := rec.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("%d mensajes guardados, se esperaban 3", len(msgs))
+	}
+	for i, m := range msgs {
+		if want := fmt.Sprintf("sensores/%d", i+3); m.Topic != want {
+			t.Fatalf("mensaje %d: %s, se esperaba %s", i, m.Topic, want)
+		}
+	}
+}
+
+func TestRecorderUnlimitedAndRetained(t *testing.T) {
+	b := New()
+	b.Publish(Message{Topic: "estado", Payload: []byte("on"), QoS: 1, Retain: true})
+	rec := b.Record("#", 0)
+	for i := 0; i < 10; i++ {
+		b.Publish(Message{Topic: "datos", Payload: []byte{byte(i)}})
+	}
+	msgs := rec.Messages()
+	if len(msgs) != 11 || rec.Count() != 11 {
+		t.Fatalf("%d mensajes (Count %d), se esperaban 11", len(msgs), rec.Count())
+	}
+	if msgs[0].Topic != "estado" || !msgs[0].Retain {
+		t.Fatalf("el primero debía ser el retenido: %+v", msgs[0])
+	}
+}
+
+func TestRecorderWaitFor(t *testing.T) {
+	b := New()
+	rec := b.Record("#", 1)
+	if rec.WaitFor(1, 20*time.Millisecond) {
+		t.Fatal("WaitFor devolvió true sin mensajes")
+	}
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(5 * time.Millisecond)
+			b.Publish(Message{Topic: "datos"})
+		}
+	}()
+	if !rec.WaitFor(4, time.Second) {
+		t.Fatalf("WaitFor venció con %d mensajes", rec.Count())
+	}
+}
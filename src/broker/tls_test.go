@@ -0,0 +1,109 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+// handshake conecta un cliente con client a un listener local con server y devuelve
+// el error de cualquiera de los dos lados
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		done <- c.(*tls.Conn).Handshake()
+	}()
+	c, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err == nil {
+		defer c.Close()
+	}
+	return errors.Join(err, <-done)
+}
+
+// clientTLS confía en ca y, si se indica, presenta un certificado firmado por signer
+func clientTLS(t *testing.T, ca, signer *CA) *tls.Config {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.PEM)
+	cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if signer != nil {
+		certPEM, keyPEM, err := signer.Issue("device-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+func TestServerTLSClientCerts(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	optional, err := ca.ServerTLS(false, "127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	required, err := ca.ServerTLS(true, "127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		server *tls.Config
+		signer *CA
+		ok     bool
+	}{
+		{"opcional sin certificado", optional, nil, true},
+		{"opcional con certificado de la CA", optional, ca, true},
+		{"opcional con certificado ajeno", optional, other, false},
+		{"obligatorio sin certificado", required, nil, false},
+		{"obligatorio con certificado de la CA", required, ca, true},
+		{"obligatorio con certificado ajeno", required, other, false},
+	}
+	for _, c := range cases {
+		err := handshake(t, c.server, clientTLS(t, ca, c.signer))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+	}
+}
+
+func TestIssueServerHosts(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ca.ServerTLS(false, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clientTLS(t, ca, nil)
+	client.ServerName = "otro.example"
+	if err := handshake(t, server, client); err == nil {
+		t.Fatal("se aceptó un certificado de servidor para otro host")
+	}
+}
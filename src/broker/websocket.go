@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrader acepta el subprotocolo "mqtt" que negocian paho y los navegadores
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"mqtt"},
+	CheckOrigin:  func(*http.Request) bool { return true },
+}
+
+// ListenAndServeWebSocket escucha MQTT sobre WebSockets en addr y path; con cfg
+// no nil sirve wss://. Devuelve la dirección real en la que quedó escuchando.
+func (b *Broker) ListenAndServeWebSocket(addr, path string, cfg *tls.Config) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("broker embebido: %w", err)
+	}
+	if cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+	go b.ServeWebSocket(ln, path)
+	return ln.Addr().String(), nil
+}
+
+// ServeWebSocket atiende en ln las peticiones HTTP de upgrade a WebSocket en path
+func (b *Broker) ServeWebSocket(ln net.Listener, path string) error {
+	if !b.addListener(ln) {
+		return net.ErrClosed
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		b.serveConn(&wsConn{ws: ws})
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: connectTimeout}
+	if err := srv.Serve(ln); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// wsConn adapta una conexión WebSocket a net.Conn: cada escritura es un mensaje
+// binario y las lecturas recorren los mensajes como un único stream
+type wsConn struct {
+	ws *websocket.Conn
+
+	rmu sync.Mutex
+	r   io.Reader
+	wmu sync.Mutex
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for {
+		if c.r == nil {
+			kind, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if kind != websocket.BinaryMessage {
+				return 0, errMalformed
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error                       { return c.ws.Close() }
+func (c *wsConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr               { return c.ws.RemoteAddr() }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,332 @@
+package core
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"simulator/src/models"
+)
+
+// CaptureConfig guarda en disco cada mensaje publicado para adjuntarlo a un bug o comparar ejecuciones
+type CaptureConfig struct {
+	Path        string   `json:"path"`         // vacío = sin captura
+	Format      string   `json:"format"`       // ndjson o csv
+	MaxBytes    int64    `json:"max_bytes"`    // rota al superar este tamaño; 0 = sin límite
+	RotateEvery Duration `json:"rotate_every"` // rota pasado este tiempo; 0 = nunca
+}
+
+// formatos de captura admitidos
+const (
+	CaptureNDJSON = "ndjson"
+	CaptureCSV    = "csv"
+)
+
+// resultados de publicación que se anotan en la captura
+const (
+	outcomeOK       = "ok"
+	outcomeBuffered = "buffered"
+	outcomeFailed   = "failed"
+)
+
+// columnas del formato CSV
+var captureColumns = []string{"time", "topic", "outcome", "error",
+	"device_id", "user_id", "bpm", "bpm2", "spo2", "temperature", "moving"}
+
+// columnas de payload v2; opcionales al leer para aceptar capturas anteriores
+var captureMetaColumns = []string{"timestamp", "seq", "message_id"}
+
+// columnas de los fallos inyectados; también opcionales al leer
+var captureFaultColumns = []string{"fault", "payload", "payload_hex"}
+
+// CaptureRecord es una línea de la captura NDJSON. Los fallos que reescriben el payload
+// (null, truncate, corrupt) no se ven en los campos de message: payload es lo que salió
+// realmente. La reproducción reenvía los campos; para repetir esos fallos hay que volver a inyectarlos.
+type CaptureRecord struct {
+	Time       time.Time       `json:"time"`
+	Topic      string          `json:"topic,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	Fault      string          `json:"fault,omitempty"`       // fallos inyectados, separados por comas
+	Payload    string          `json:"payload,omitempty"`     // payload reescrito por un fallo, si es texto
+	PayloadHex string          `json:"payload_hex,omitempty"` // o en hexadecimal si es binario
+	Message    *models.Message `json:"message"`
+}
+
+// setPayload anota el payload reescrito por un fallo, legible si es texto
+func (r *CaptureRecord) setPayload(raw []byte) {
+	switch {
+	case raw == nil:
+	case utf8.Valid(raw):
+		r.Payload = string(raw)
+	default:
+		r.PayloadHex = hex.EncodeToString(raw)
+	}
+}
+
+// validate revisa la sección capture
+func (c CaptureConfig) validate() []error {
+	var errs []error
+	if c.Format != CaptureNDJSON && c.Format != CaptureCSV {
+		errs = append(errs, fmt.Errorf("capture.format %q no válido: use %s o %s", c.Format, CaptureNDJSON, CaptureCSV))
+	}
+	if c.MaxBytes < 0 || c.RotateEvery < 0 {
+		errs = append(errs, fmt.Errorf("capture.max_bytes y capture.rotate_every no pueden ser negativos"))
+	}
+	return errs
+}
+
+// captureTopic elige el destino que se anota en la captura: el tópico MQTT, la
+// routing key AMQP o la URL HTTP, según el primer sink que lo tenga
+func (c *Config) captureTopic() string {
+	switch {
+	case c.UsesSink(SinkMQTT):
+		return c.Topics.Publish
+	case c.UsesSink(SinkAMQP):
+		return c.AMQP.RoutingKey
+	case c.UsesSink(SinkHTTP):
+		return c.HTTP.URL
+	}
+	return ""
+}
+
+// captureSink anota cada mensaje con el resultado de publicarlo en inner
+type captureSink struct {
+	inner Sink
+	topic string // plantilla del tópico o routing key con la que sale el mensaje
+	csv   bool
+
+	mu      sync.Mutex
+	out     *rotatingFile
+	pending map[*CaptureRecord]bool // mensajes que el sink entregará más tarde
+}
+
+// WithCapture envuelve un sink y escribe en disco cada mensaje y su resultado
+func WithCapture(inner Sink, cfg CaptureConfig, topic string) (Sink, error) {
+	s := &captureSink{inner: inner, topic: topic, csv: cfg.Format == CaptureCSV, pending: make(map[*CaptureRecord]bool)}
+	out, err := newRotatingFile(cfg.Path, cfg.MaxBytes, time.Duration(cfg.RotateEvery), s.header)
+	if err != nil {
+		return nil, err
+	}
+	s.out = out
+	return s, nil
+}
+
+// Publish anota el mensaje con su resultado final: si el sink lo deja para más tarde
+// (buffer offline, lote HTTP) el registro se escribe cuando se entrega o falla, y los que
+// siguen pendientes al cerrar quedan como buffered.
+func (s *captureSink) Publish(ctx context.Context, msg *models.Message) error {
+	rec := &CaptureRecord{
+		Time:    SimClock().Now(),
+		Topic:   renderTemplate(s.topic, msg),
+		Outcome: outcomeOK,
+		Fault:   msg.Fault,
+		Message: msg,
+	}
+	rec.setPayload(msg.Raw)
+	s.mu.Lock()
+	s.pending[rec] = true
+	s.mu.Unlock()
+
+	settle := settleFrom(ctx)
+	err := s.inner.Publish(withSettle(ctx, func(err error) {
+		s.finish(rec, err)
+		settle(err)
+	}), msg)
+	if !errors.Is(err, errBuffered) {
+		s.finish(rec, err)
+	}
+	return err
+}
+
+// finish escribe el registro con su resultado, sólo la primera vez
+func (s *captureSink) finish(rec *CaptureRecord, err error) {
+	s.mu.Lock()
+	pending := s.pending[rec]
+	delete(s.pending, rec)
+	s.mu.Unlock()
+	if !pending {
+		return
+	}
+	if err != nil {
+		rec.Outcome = outcomeFailed
+		rec.Error = err.Error()
+	}
+	if werr := s.write(*rec); werr != nil {
+		// la captura no debe frenar la simulación: se avisa y se sigue
+		fmt.Println("Error al escribir la captura:", werr)
+	}
+}
+
+func (s *captureSink) write(rec CaptureRecord) error {
+	var line []byte
+	if s.csv {
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		m := rec.Message
+		w.Write([]string{
+			rec.Time.Format(time.RFC3339Nano), rec.Topic, rec.Outcome, rec.Error,
+			strconv.Itoa(m.DeviceId), strconv.Itoa(m.UserID), strconv.Itoa(m.Bpm), strconv.Itoa(m.Bpm2),
+			strconv.Itoa(m.Spo2), strconv.FormatFloat(m.Temperature, 'f', -1, 64), strconv.FormatBool(m.Moving),
+			formatMeta(m.Timestamp), formatMeta(m.Seq), m.MessageID,
+			rec.Fault, rec.Payload, rec.PayloadHex,
+		})
+		w.Flush()
+		line = []byte(b.String())
+	} else {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		line = append(data, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.out.Write(line)
+	return err
+}
+
+// formatMeta escribe un metadato de payload v2; vacío si el mensaje no lo lleva
+func formatMeta(v any) string {
+	if v == nil || v == uint64(0) {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// header es la cabecera de cada archivo nuevo (sólo en CSV)
+func (s *captureSink) header() []byte {
+	if !s.csv {
+		return nil
+	}
+	return []byte(strings.Join(slices.Concat(captureColumns, captureMetaColumns, captureFaultColumns), ",") + "\n")
+}
+
+func (s *captureSink) Close() error {
+	err := s.inner.Close()
+	// lo que sigue sin entregar (p. ej. en el buffer offline) se anota como buffered
+	s.mu.Lock()
+	left := make([]*CaptureRecord, 0, len(s.pending))
+	for rec := range s.pending {
+		left = append(left, rec)
+	}
+	s.pending = nil
+	s.mu.Unlock()
+	slices.SortFunc(left, func(a, b *CaptureRecord) int { return a.Time.Compare(b.Time) })
+	for _, rec := range left {
+		rec.Outcome = outcomeBuffered
+		if werr := s.write(*rec); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(err, s.out.Close())
+}
+
+// discardSink acepta todo sin enviarlo: es el sink "file", para capturar sin publicar
+type discardSink struct{}
+
+func (discardSink) Publish(context.Context, *models.Message) error { return nil }
+func (discardSink) Close() error                                   { return nil }
+
+// rotatingFile escribe en path y, al superar maxBytes o pasado every, renombra el
+// archivo con la hora de apertura y empieza otro
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	every    time.Duration
+	header   func() []byte
+
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingFile(path string, maxBytes int64, every time.Duration, header func() []byte) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes, every: every, header: header}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("no se pudo crear el directorio de captura: %w", err)
+		}
+	}
+	// la captura de una ejecución anterior no se pisa: se aparta como una rotación más
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		if err := os.Rename(path, r.rotatedPath(info.ModTime())); err != nil {
+			return nil, fmt.Errorf("no se pudo apartar la captura anterior: %w", err)
+		}
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return fmt.Errorf("no se pudo abrir la captura: %w", err)
+	}
+	r.f, r.size, r.opened = f, 0, time.Now()
+	if h := r.header(); len(h) > 0 {
+		n, err := f.Write(h)
+		r.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	full := r.maxBytes > 0 && r.size+int64(len(p)) > r.maxBytes && r.size > int64(len(r.header()))
+	expired := r.every > 0 && time.Since(r.opened) >= r.every
+	if full || expired {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate cierra el archivo actual como <nombre>-<hora de apertura><ext> y abre uno nuevo
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.rotatedPath(r.opened)); err != nil {
+		return fmt.Errorf("no se pudo rotar la captura: %w", err)
+	}
+	return r.open()
+}
+
+// rotatedPath es el nombre de un archivo de captura abierto en opened, ya rotado
+func (r *rotatingFile) rotatedPath(opened time.Time) string {
+	ext := filepath.Ext(r.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), opened.Format("20060102T150405.000"), ext)
+}
+
+func (r *rotatingFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"simulator/src/models"
+)
+
+func TestCaptureRecordsDeferredOutcome(t *testing.T) {
+	setPayload(PayloadConfig{Version: PayloadV1, Encoding: EncodingJSON})
+	srv := newIngestServer(t, http.StatusBadRequest)
+	path := filepath.Join(t.TempDir(), "captura.ndjson")
+	sink, err := WithCapture(NewHTTPSink(batchConfig(srv.URL), RetryPolicy{MaxAttempts: 1, Multiplier: 1}),
+		CaptureConfig{Path: path, Format: CaptureNDJSON}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed int
+	ctx := withSettle(context.Background(), func(err error) {
+		if err != nil {
+			failed++
+		}
+	})
+	for i := 1; i <= 4; i++ {
+		sink.Publish(ctx, &models.Message{DeviceId: i})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := LoadCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// el lote de 3 y el del cierre fallan: ninguno queda como buffered
+	if len(records) != 4 {
+		t.Fatalf("%d registros, se esperaban 4", len(records))
+	}
+	for _, rec := range records {
+		if rec.Outcome != outcomeFailed {
+			t.Fatalf("device %d con outcome %s, se esperaba failed", rec.Message.DeviceId, rec.Outcome)
+		}
+	}
+	if failed != 3 {
+		t.Fatalf("%d diferidos fallidos, se esperaban 3", failed)
+	}
+}
+
+func TestCaptureKeepsPreviousFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "captura.ndjson")
+	if err := os.WriteFile(path, []byte("anterior\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := WithCapture(discardSink{}, CaptureConfig{Path: path, Format: CaptureNDJSON}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "captura-*.ndjson"))
+	if len(matches) != 1 {
+		t.Fatalf("archivos apartados %v, se esperaba uno", matches)
+	}
+	if data, _ := os.ReadFile(matches[0]); string(data) != "anterior\n" {
+		t.Fatalf("la captura anterior cambió: %q", data)
+	}
+}
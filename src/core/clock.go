@@ -0,0 +1,310 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// modos de reloj de la simulación
+const (
+	ClockReal   = "real"   // tiempo de pared
+	ClockScaled = "scaled" // tiempo acelerado (o ralentizado) por clock.speed
+	ClockManual = "manual" // el tiempo avanza por pasos de clock.step, tan rápido como se publique
+)
+
+// ClockConfig elige el reloj con el que avanzan los devices, el escenario y la duración
+type ClockConfig struct {
+	Mode  string    `json:"mode"`  // real (por defecto), scaled o manual
+	Speed float64   `json:"speed"` // scaled: segundos simulados por segundo real
+	Start time.Time `json:"start"` // instante simulado inicial en RFC3339; sin indicar = ahora
+	Step  Duration  `json:"step"`  // manual: avance de cada paso; obligatorio en ese modo
+}
+
+func (c ClockConfig) validate() []error {
+	var errs []error
+	switch c.Mode {
+	case "", ClockReal:
+	case ClockManual:
+		if c.Step <= 0 {
+			errs = append(errs, fmt.Errorf("clock.step debe ser positivo en modo manual (es %s)", time.Duration(c.Step)))
+		}
+	case ClockScaled:
+		if c.Speed <= 0 {
+			errs = append(errs, fmt.Errorf("clock.speed debe ser positiva en modo scaled (es %g)", c.Speed))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("clock.mode %q desconocido (use %s, %s o %s)", c.Mode, ClockReal, ClockScaled, ClockManual))
+	}
+	if c.Step < 0 && c.Mode != ClockManual {
+		errs = append(errs, fmt.Errorf("clock.step no puede ser negativo"))
+	}
+	return errs
+}
+
+// Clock da la hora simulada y temporizadores que avanzan con ella
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	// Sleep espera d de tiempo simulado o hasta que se cancele ctx
+	Sleep(ctx context.Context, d time.Duration)
+}
+
+// Ticker entrega en C la hora simulada de cada tick
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// reloj de la ejecución en curso; fuera de una simulación es el de pared
+var (
+	clockMu  sync.Mutex
+	simClock Clock = realClock{}
+)
+
+// SimClock devuelve el reloj de la ejecución en curso o de la última
+func SimClock() Clock {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	return simClock
+}
+
+func setClock(c Clock) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	simClock = c
+}
+
+// newClock crea el reloj descrito en la configuración
+func newClock(c ClockConfig) Clock {
+	start := c.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	switch c.Mode {
+	case ClockScaled:
+		return NewScaledClock(start, c.Speed)
+	case ClockManual:
+		return NewManualClock(start)
+	}
+	if !c.Start.IsZero() {
+		// hora de inicio fija a velocidad real
+		return NewScaledClock(start, 1)
+	}
+	return realClock{}
+}
+
+// realClock es el reloj de pared
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// ScaledClock avanza speed segundos simulados por cada segundo real desde start
+type ScaledClock struct {
+	start time.Time // instante simulado inicial
+	real  time.Time // instante real en que empezó
+	speed float64
+}
+
+// NewScaledClock crea un reloj que arranca en start y corre speed veces más rápido que el real
+func NewScaledClock(start time.Time, speed float64) *ScaledClock {
+	return &ScaledClock{start: start, real: time.Now(), speed: speed}
+}
+
+func (c *ScaledClock) Now() time.Time {
+	return c.simulated(time.Now())
+}
+
+func (c *ScaledClock) simulated(real time.Time) time.Time {
+	return c.start.Add(time.Duration(float64(real.Sub(c.real)) * c.speed))
+}
+
+func (c *ScaledClock) toReal(d time.Duration) time.Duration {
+	return max(time.Duration(float64(d)/c.speed), time.Nanosecond)
+}
+
+func (c *ScaledClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(c.toReal(d), func() { ch <- c.Now() })
+	return ch
+}
+
+func (c *ScaledClock) Sleep(ctx context.Context, d time.Duration) {
+	realClock{}.Sleep(ctx, c.toReal(d))
+}
+
+// NewTicker entrega la hora simulada; como time.Ticker, descarta ticks si nadie los lee
+func (c *ScaledClock) NewTicker(d time.Duration) Ticker {
+	t := &scaledTicker{t: time.NewTicker(c.toReal(d)), c: make(chan time.Time, 1), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case now := <-t.t.C:
+				select {
+				case t.c <- c.simulated(now):
+				default:
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+type scaledTicker struct {
+	t    *time.Ticker
+	c    chan time.Time
+	done chan struct{}
+	once sync.Once
+}
+
+func (t *scaledTicker) C() <-chan time.Time { return t.c }
+
+func (t *scaledTicker) Stop() {
+	t.once.Do(func() {
+		t.t.Stop()
+		close(t.done)
+	})
+}
+
+// ManualClock sólo avanza con Advance. Los ticks no se descartan: Advance espera a que cada
+// device reciba el suyo; Sleep no espera porque entre dos pasos no transcurre tiempo.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  timerHeap
+	created uint64     // desempata los vencimientos simultáneos por orden de creación
+	advance sync.Mutex // serializa los Advance
+}
+
+type manualTimer struct {
+	at     time.Time
+	period time.Duration // 0 = disparo único
+	order  uint64
+	c      chan time.Time
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewManualClock crea un reloj detenido en start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Sleep(context.Context, time.Duration) {}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	return c.add(d, d)
+}
+
+func (c *ManualClock) add(d, period time.Duration) *manualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created++
+	// los tickers no tienen buffer: Advance no sigue hasta que el device recibe el tick
+	buffer := 0
+	if period == 0 {
+		buffer = 1
+	}
+	t := &manualTimer{at: c.now.Add(d), period: period, order: c.created, c: make(chan time.Time, buffer), done: make(chan struct{})}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+// Advance avanza el reloj d y dispara en orden los temporizadores que vencen por el camino
+func (c *ManualClock) Advance(d time.Duration) {
+	c.advance.Lock()
+	defer c.advance.Unlock()
+
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		if len(c.timers) == 0 || c.timers[0].at.After(target) {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		t := heap.Pop(&c.timers).(*manualTimer)
+		if t.stopped() {
+			continue
+		}
+		c.now = t.at
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+			heap.Push(&c.timers, t)
+		}
+		now := c.now
+		c.mu.Unlock()
+
+		select {
+		case t.c <- now:
+		case <-t.done:
+		}
+		c.mu.Lock()
+	}
+}
+
+func (t *manualTimer) C() <-chan time.Time { return t.c }
+
+func (t *manualTimer) Stop() {
+	t.once.Do(func() { close(t.done) })
+}
+
+func (t *manualTimer) stopped() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// timerHeap ordena los temporizadores del reloj manual por vencimiento
+type timerHeap []*manualTimer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at) || h[i].at.Equal(h[j].at) && h[i].order < h[j].order
+}
+func (h timerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x any)   { *h = append(*h, x.(*manualTimer)) }
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"simulator/src/models"
+)
+
+var testStart = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestManualClockTicksOnAdvance(t *testing.T) {
+	clk := NewManualClock(testStart)
+	ticker := clk.NewTicker(time.Minute)
+	defer ticker.Stop()
+	after := clk.After(90 * time.Second)
+
+	// los ticks no tienen buffer: Advance espera a que se reciba cada uno
+	go clk.Advance(3 * time.Minute)
+	for i := 1; i <= 3; i++ {
+		got := <-ticker.C()
+		if want := testStart.Add(time.Duration(i) * time.Minute); !got.Equal(want) {
+			t.Fatalf("tick %d: %s, se esperaba %s", i, got, want)
+		}
+	}
+	select {
+	case got := <-after:
+		if want := testStart.Add(90 * time.Second); !got.Equal(want) {
+			t.Fatalf("After: %s, se esperaba %s", got, want)
+		}
+	default:
+		t.Fatal("After no se disparó al pasar su vencimiento")
+	}
+}
+
+func TestManualClockSleepDoesNotWait(t *testing.T) {
+	clk := NewManualClock(testStart)
+	done := make(chan struct{})
+	go func() {
+		clk.Sleep(context.Background(), time.Hour)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sleep del reloj manual bloqueó")
+	}
+	if !clk.Now().Equal(testStart) {
+		t.Fatalf("Sleep movió el reloj a %s", clk.Now())
+	}
+}
+
+// memorySink guarda lo publicado para las pruebas
+type memorySink struct {
+	mu   sync.Mutex
+	msgs []models.Message
+}
+
+func (s *memorySink) Publish(_ context.Context, msg *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, *msg)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+// runManual ejecuta una simulación completa con reloj manual, sin esperas reales
+func runManual(t *testing.T, cfg *Config) []models.Message {
+	t.Helper()
+	sink := &memorySink{}
+	if err := StartSimulationWithSink(cfg, sink); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-SimulationDone():
+	case <-time.After(10 * time.Second):
+		StopSimulation()
+		t.Fatal("la simulación no terminó")
+	}
+	slices.SortFunc(sink.msgs, func(a, b models.Message) int {
+		if a.DeviceId != b.DeviceId {
+			return a.DeviceId - b.DeviceId
+		}
+		return int(a.Seq) - int(b.Seq)
+	})
+	return sink.msgs
+}
+
+func manualConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Sinks = []string{SinkStdout}
+	cfg.Fleet = []DeviceGroup{{FirstID: 1, LastID: 3, Interval: Duration(time.Minute), Profile: DefaultProfile}}
+	cfg.Duration = Duration(time.Hour)
+	cfg.Seed = 42
+	cfg.Clock = ClockConfig{Mode: ClockManual, Start: testStart, Step: Duration(time.Minute)}
+	cfg.Payload = PayloadConfig{Version: PayloadV2, Timestamp: TimestampRFC3339}
+	return cfg
+}
+
+func TestManualClockRunIsDeterministic(t *testing.T) {
+	first := runManual(t, manualConfig())
+	// una lectura por minuto de [1m, 60m): la del instante final queda fuera
+	if len(first) != 3*59 {
+		t.Fatalf("%d mensajes, se esperaban %d", len(first), 3*59)
+	}
+	second := runManual(t, manualConfig())
+	if !slices.EqualFunc(first, second, func(a, b models.Message) bool {
+		return a.DeviceId == b.DeviceId && a.Seq == b.Seq && a.MessageID == b.MessageID &&
+			a.Bpm == b.Bpm && a.Spo2 == b.Spo2 && a.Temperature == b.Temperature && a.Timestamp == b.Timestamp
+	}) {
+		t.Fatal("la misma semilla con reloj manual produjo otra serie")
+	}
+	if got, want := first[0].Timestamp, "2026-01-01T00:01:00.000Z"; got != want {
+		t.Fatalf("primer timestamp %v, se esperaba %s", got, want)
+	}
+}
@@ -0,0 +1,560 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config describe una ejecución completa de la simulación
+type Config struct {
+	Sinks        []string                 `json:"sinks"` // mqtt, amqp, http, stdout, file
+	Broker       BrokerConfig             `json:"broker"`
+	AMQP         AMQPConfig               `json:"amqp"`
+	HTTP         HTTPConfig               `json:"http"`
+	Capture      CaptureConfig            `json:"capture"`
+	Replay       ReplayConfig             `json:"replay"`
+	Topics       TopicsConfig             `json:"topics"`
+	Fleet        []DeviceGroup            `json:"fleet"`
+	Profiles     map[string]SensorProfile `json:"profiles"`
+	Datasets     map[string]DatasetConfig `json:"datasets"`
+	Workers      WorkerConfig             `json:"workers"`
+	Retry        RetryPolicy              `json:"retry"`
+	Duration     Duration                 `json:"duration"` // 0 = hasta detenerla a mano
+	ScenarioFile string                   `json:"scenario"` // relativo al archivo de configuración
+	Seed         int64                    `json:"seed"`     // 0 = una nueva en cada ejecución
+	Clock        ClockConfig              `json:"clock"`
+	Payload      PayloadConfig            `json:"payload"`
+	Faults       FaultConfig              `json:"faults"`
+	SensorFaults []SensorFault            `json:"sensor_faults"`
+
+	Scenario *Scenario `json:"-"`
+}
+
+// BrokerConfig contiene los datos de conexión al broker MQTT
+type BrokerConfig struct {
+	Embedded bool   `json:"embedded"` // levantar un broker MQTT en proceso en Host
+	Host     string `json:"host"`     // host:puerto o URL tcp://, ssl://, mqtts://, ws://, wss://
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	ConnectRetryInterval Duration `json:"connect_retry_interval"`
+	MaxReconnectInterval Duration `json:"max_reconnect_interval"`
+	OfflineBuffer        int      `json:"offline_buffer"` // mensajes guardados sin conexión; 0 = deshabilitado
+
+	PerDeviceClients bool       `json:"per_device_clients"` // un cliente MQTT por device, con client ID <client_id>-<device_id>
+	ConnectRate      float64    `json:"connect_rate"`       // conexiones nuevas por segundo con per_device_clients; 0 = sin límite
+	Will             WillConfig `json:"will"`
+
+	TLS       TLSConfig       `json:"tls"`
+	WebSocket WebSocketConfig `json:"websocket"`
+}
+
+// WillConfig es el mensaje de presencia de cada device; solo se usa con per_device_clients
+type WillConfig struct {
+	Topic         string `json:"topic"` // admite {device_id} y {user_id}; vacío = sin Last Will
+	Payload       string `json:"payload"`
+	OnlinePayload string `json:"online_payload"` // se publica al conectar; vacío = no se publica
+	QoS           byte   `json:"qos"`
+	Retain        bool   `json:"retain"`
+}
+
+// TopicsConfig agrupa los tópicos de publicación y suscripción
+type TopicsConfig struct {
+	Publish   string `json:"publish"` // admite {device_id}, {user_id} y, con per_sensor, {sensor}
+	Subscribe string `json:"subscribe"`
+	PerSensor bool   `json:"per_sensor"` // un tópico por sensor con el valor en texto plano
+
+	QoS          byte                      `json:"qos"`
+	Retain       bool                      `json:"retain"`
+	SubscribeQoS byte                      `json:"subscribe_qos"`
+	Sensors      map[string]PublishOptions `json:"sensors"` // QoS y retain propios de cada sensor con per_sensor
+}
+
+// PublishOptions son la QoS y el flag retain con los que se publica en un tópico
+type PublishOptions struct {
+	QoS    byte `json:"qos"`
+	Retain bool `json:"retain"`
+}
+
+// publishOptions devuelve las opciones del tópico de un sensor, o las generales con sensor vacío
+func (t TopicsConfig) publishOptions(sensor string) PublishOptions {
+	if opts, ok := t.Sensors[sensor]; ok {
+		return opts
+	}
+	return PublishOptions{QoS: t.QoS, Retain: t.Retain}
+}
+
+// DeviceGroup es un rango de dispositivos que comparten configuración
+type DeviceGroup struct {
+	FirstID    int      `json:"first_id"`
+	LastID     int      `json:"last_id"`
+	UserOffset int      `json:"user_offset"` // user_id = device_id + user_offset
+	Interval   Duration `json:"interval"`
+	Profile    string   `json:"profile"`
+	Source     string   `json:"source"`  // random_walk, uniform o dataset; vacío = dataset si hay uno, si no random_walk
+	Dataset    string   `json:"dataset"` // nombre en datasets
+}
+
+// SensorProfile define la línea base fisiológica de un grupo de pacientes
+type SensorProfile struct {
+	HeartRate   Range `json:"heart_rate"`
+	SpO2        Range `json:"spo2"`
+	Temperature Range `json:"temperature"`
+}
+
+// Range es un intervalo cerrado [Min, Max]
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// WorkerConfig acota el tamaño del pool de workers
+type WorkerConfig struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// DefaultProfile es el perfil usado cuando un grupo no indica ninguno
+const DefaultProfile = "adulto"
+
+// Duration es un time.Duration que en JSON se escribe como "30s", "2m"...
+type Duration time.Duration
+
+// UnmarshalJSON acepta un string de time.ParseDuration o un número de segundos
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("duración inválida %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return fmt.Errorf("duración inválida %s: use \"30s\" o un número de segundos", string(data))
+	}
+	*d = Duration(secs * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON escribe la duración en formato legible
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultSensorProfile es la línea base de un adulto sano en reposo
+func defaultSensorProfile() SensorProfile {
+	return SensorProfile{
+		HeartRate:   Range{62, 82},
+		SpO2:        Range{96, 98.5},
+		Temperature: Range{36.3, 36.9},
+	}
+}
+
+// loadEnv carga el .env una sola vez por proceso
+var loadEnv = sync.OnceFunc(func() {
+	if err := godotenv.Load(); err != nil {
+		fmt.Println("No se pudieron cargar las variables de entorno")
+	}
+})
+
+// LoadEnv carga el .env en las variables de entorno; llamarla antes de DefaultConfig o LoadConfig
+func LoadEnv() {
+	loadEnv()
+}
+
+// DefaultConfig arma la configuración a partir de las variables de entorno y GlobalDeviceCount
+func DefaultConfig() *Config {
+	return &Config{
+		Sinks: []string{SinkMQTT},
+		Broker: BrokerConfig{
+			Host:     os.Getenv("HOST_RABBIT"),
+			ClientID: os.Getenv("CLIENT_ID"),
+			Username: os.Getenv("USER_RABBIT"),
+			Password: os.Getenv("PASSWORD_RABBIT"),
+
+			ConnectRetryInterval: Duration(2 * time.Second),
+			MaxReconnectInterval: Duration(30 * time.Second),
+			OfflineBuffer:        10000,
+			ConnectRate:          100,
+			WebSocket:            WebSocketConfig{Path: "/mqtt"},
+			Will: WillConfig{
+				Payload:       "offline",
+				OnlinePayload: "online",
+				QoS:           1,
+				Retain:        true,
+			},
+		},
+		AMQP: AMQPConfig{
+			URL:          os.Getenv("AMQP_URL"),
+			Exchange:     "warmheart.vitals",
+			ExchangeType: "topic",
+			Durable:      true,
+			RoutingKey:   "vitals.{user_id}.{device_id}",
+			Persistent:   true,
+			Confirms:     true,
+		},
+		HTTP: HTTPConfig{
+			URL:           os.Getenv("HTTP_URL"),
+			Timeout:       Duration(5 * time.Second),
+			BatchSize:     1,
+			FlushInterval: Duration(time.Second),
+		},
+		Capture: CaptureConfig{Format: CaptureNDJSON},
+		Replay:  ReplayConfig{Speed: 1},
+		Payload: PayloadConfig{Version: PayloadV1, Timestamp: TimestampRFC3339, Encoding: EncodingJSON},
+		Faults:  FaultConfig{ReorderWindow: 5},
+		Topics: TopicsConfig{
+			Publish:   os.Getenv("TOPICPUB"),
+			Subscribe: os.Getenv("TOPICCON"),
+
+			SubscribeQoS: 1,
+		},
+		Fleet: []DeviceGroup{{
+			FirstID:  2,
+			LastID:   GlobalDeviceCount + 1,
+			Interval: Duration(time.Second),
+			Profile:  DefaultProfile,
+		}},
+		Profiles: map[string]SensorProfile{
+			DefaultProfile: defaultSensorProfile(),
+		},
+		Workers: WorkerConfig{Min: 4, Max: 500},
+		Retry:   defaultRetryPolicy(),
+	}
+}
+
+// LoadConfig lee un archivo JSON sobre los valores por defecto. No lo valida: el llamador
+// llama a Validate una vez aplicados sus propios cambios (flags, broker embebido)
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer la configuración: %w", err)
+	}
+
+	cfg := DefaultConfig()
+	// la flota del archivo reemplaza a la de por defecto en lugar de mezclarse con ella
+	defaultFleet := cfg.Fleet
+	cfg.Fleet = nil
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("configuración %s mal formada: %w", path, err)
+	}
+	if cfg.Fleet == nil {
+		cfg.Fleet = defaultFleet
+	}
+
+	// como el escenario, los certificados y la captura a reproducir se buscan junto al archivo de configuración
+	for _, p := range []*string{&cfg.Broker.TLS.CAFile, &cfg.Broker.TLS.CertFile, &cfg.Broker.TLS.KeyFile, &cfg.Replay.File} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(filepath.Dir(path), *p)
+		}
+	}
+	for name, d := range cfg.Datasets {
+		if d.File != "" && !filepath.IsAbs(d.File) {
+			d.File = filepath.Join(filepath.Dir(path), d.File)
+			cfg.Datasets[name] = d
+		}
+	}
+	if cfg.ScenarioFile != "" {
+		scenarioPath := cfg.ScenarioFile
+		if !filepath.IsAbs(scenarioPath) {
+			scenarioPath = filepath.Join(filepath.Dir(path), scenarioPath)
+		}
+		if cfg.Scenario, err = LoadScenario(scenarioPath); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// Validate revisa toda la configuración y devuelve todos los problemas encontrados.
+// Si es válida lee además los datasets de la flota, que quedan en caché para la simulación.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(c.Sinks) == 0 {
+		fail("sinks vacío: indique al menos uno (%s, %s, %s, %s, %s)", SinkMQTT, SinkAMQP, SinkHTTP, SinkStdout, SinkFile)
+	}
+	seen := make(map[string]bool)
+	for _, name := range c.Sinks {
+		if seen[name] {
+			fail("sinks: %q repetido", name)
+			continue
+		}
+		seen[name] = true
+		c.validateSink(name, fail)
+	}
+
+	for name, p := range c.Profiles {
+		checkRange := func(field string, r Range, lo, hi float64) {
+			if r.Min > r.Max {
+				fail("profiles.%s.%s: min (%g) mayor que max (%g)", name, field, r.Min, r.Max)
+			}
+			if r.Min < lo || r.Max > hi {
+				fail("profiles.%s.%s: [%g, %g] fuera del rango fisiológico [%g, %g]", name, field, r.Min, r.Max, lo, hi)
+			}
+		}
+		checkRange("heart_rate", p.HeartRate, 30, 220)
+		checkRange("spo2", p.SpO2, 50, 100)
+		checkRange("temperature", p.Temperature, 30, 43)
+	}
+
+	if len(c.Fleet) == 0 {
+		fail("fleet vacío: defina al menos un grupo de dispositivos")
+	}
+	for i, g := range c.Fleet {
+		if g.FirstID <= 0 {
+			fail("fleet[%d].first_id debe ser positivo (es %d)", i, g.FirstID)
+		}
+		if g.LastID < g.FirstID {
+			fail("fleet[%d].last_id (%d) menor que first_id (%d)", i, g.LastID, g.FirstID)
+		}
+		if g.FirstID+g.UserOffset <= 0 {
+			fail("fleet[%d].user_offset (%d) produce user_id no positivos", i, g.UserOffset)
+		}
+		if time.Duration(g.Interval) < 10*time.Millisecond {
+			fail("fleet[%d].interval (%s) debe ser de al menos 10ms", i, time.Duration(g.Interval))
+		}
+		if _, ok := c.Profiles[c.groupProfileName(g)]; !ok {
+			fail("fleet[%d].profile %q no existe en profiles", i, g.Profile)
+		}
+		switch source := groupSourceName(g); {
+		case !slices.Contains(sensorSources, source):
+			fail("fleet[%d].source %q desconocida (use %s)", i, g.Source, strings.Join(sensorSources, ", "))
+		case source == SourceDataset && g.Dataset == "":
+			fail("fleet[%d].source dataset requiere indicar dataset", i)
+		case source != SourceDataset && g.Dataset != "":
+			fail("fleet[%d].dataset sólo se usa con source dataset (es %s)", i, source)
+		}
+		if _, ok := c.Datasets[g.Dataset]; g.Dataset != "" && !ok {
+			fail("fleet[%d].dataset %q no existe en datasets", i, g.Dataset)
+		}
+	}
+	for _, o := range fleetOverlaps(c.Fleet) {
+		fail("fleet[%d] se solapa con fleet[%d] (device_id %d)", o[0], o[1], o[2])
+	}
+
+	for name, d := range c.Datasets {
+		if d.File == "" {
+			fail("datasets.%s.file vacío", name)
+		}
+		if d.Stagger < 0 {
+			fail("datasets.%s.stagger no puede ser negativo", name)
+		}
+	}
+
+	if c.Workers.Min < 1 {
+		fail("workers.min debe ser al menos 1 (es %d)", c.Workers.Min)
+	}
+	if c.Workers.Max < c.Workers.Min {
+		fail("workers.max (%d) menor que workers.min (%d)", c.Workers.Max, c.Workers.Min)
+	}
+	errs = append(errs, c.Retry.validate("retry")...)
+	if c.Capture.Path != "" {
+		errs = append(errs, c.Capture.validate()...)
+	}
+	errs = append(errs, c.Replay.validate()...)
+	errs = append(errs, c.Clock.validate()...)
+	if c.Clock.Mode == ClockManual && c.Replay.File != "" {
+		// la reproducción sigue los tiempos de la captura en tiempo real: el reloj manual
+		// avanzaría sin esperarla y la duración vencería al instante
+		fail("clock.mode manual no admite replay: use replay.speed para acelerar la reproducción")
+	}
+	errs = append(errs, c.Payload.validate()...)
+	if c.Payload.encoding() == EncodingESP32 && c.Topics.PerSensor && c.UsesSink(SinkMQTT) {
+		fail("payload.encoding esp32 no admite topics.per_sensor")
+	}
+	errs = append(errs, c.Faults.validate()...)
+	for i, f := range c.SensorFaults {
+		errs = append(errs, f.validate(i)...)
+	}
+	if c.Faults.Null > 0 && c.Payload.encoding() == EncodingESP32 {
+		fail("faults.null no se aplica a la trama fija esp32")
+	}
+	if c.Faults.payloadFaults() && c.Topics.PerSensor && c.UsesSink(SinkMQTT) {
+		fail("faults.truncate, corrupt y null no se aplican a topics.per_sensor")
+	}
+	if c.Duration < 0 {
+		fail("duration no puede ser negativa")
+	}
+	if c.Scenario != nil {
+		if err := c.Scenario.Validate(); err != nil {
+			fail("scenario: %v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return c.loadDatasets()
+}
+
+// validateSink revisa los campos que necesita un sink concreto
+func (c *Config) validateSink(name string, fail func(string, ...any)) {
+	switch name {
+	case SinkMQTT:
+		// con el broker embebido el host y el client ID se completan al arrancarlo
+		if c.Broker.Host == "" && !c.Broker.Embedded {
+			fail("broker.host vacío: indíquelo en el archivo o en HOST_RABBIT")
+		}
+		if c.Broker.ClientID == "" && !c.Broker.Embedded {
+			fail("broker.client_id vacío: indíquelo en el archivo o en CLIENT_ID")
+		}
+		if c.Broker.ConnectRetryInterval <= 0 || c.Broker.MaxReconnectInterval <= 0 {
+			fail("broker.connect_retry_interval y broker.max_reconnect_interval deben ser positivos")
+		}
+		if c.Broker.OfflineBuffer < 0 {
+			fail("broker.offline_buffer no puede ser negativo (es %d)", c.Broker.OfflineBuffer)
+		}
+		for _, err := range c.Broker.TLS.validate(c.Broker.Host) {
+			fail("%v", err)
+		}
+		for _, err := range c.Broker.WebSocket.validate(c.Broker.Host) {
+			fail("%v", err)
+		}
+		if c.Broker.ConnectRate < 0 {
+			fail("broker.connect_rate no puede ser negativo (es %g)", c.Broker.ConnectRate)
+		}
+		if c.Topics.Publish == "" {
+			fail("topics.publish vacío: indíquelo en el archivo o en TOPICPUB")
+		} else if err := validatePublishTopic("topics.publish", c.Topics.Publish, c.Topics.PerSensor); err != nil {
+			fail("%v", err)
+		}
+		if c.Topics.QoS > 2 || c.Topics.SubscribeQoS > 2 {
+			fail("topics.qos y topics.subscribe_qos deben estar entre 0 y 2")
+		}
+		for sensor, opts := range c.Topics.Sensors {
+			if !slices.Contains(sensorNames, sensor) {
+				fail("topics.sensors: sensor %q no válido: use %s", sensor, strings.Join(sensorNames, ", "))
+			} else if opts.QoS > 2 {
+				fail("topics.sensors.%s.qos debe estar entre 0 y 2 (es %d)", sensor, opts.QoS)
+			}
+		}
+		if will := c.Broker.Will; will.Topic != "" {
+			if err := validatePublishTopic("broker.will.topic", will.Topic, false); err != nil {
+				fail("%v", err)
+			}
+			if will.QoS > 2 {
+				fail("broker.will.qos debe estar entre 0 y 2 (es %d)", will.QoS)
+			}
+		}
+	case SinkAMQP:
+		if c.AMQP.URL == "" {
+			fail("amqp.url vacía: indíquela en el archivo o en AMQP_URL")
+		}
+		if c.AMQP.Exchange != "" && !amqpExchangeTypes[c.AMQP.ExchangeType] {
+			fail("amqp.exchange_type %q no válido: use direct, topic, fanout o headers", c.AMQP.ExchangeType)
+		}
+		if c.AMQP.Exchange == "" && c.AMQP.RoutingKey == "" {
+			fail("amqp.routing_key vacía: con el exchange por defecto debe ser el nombre de la cola")
+		}
+		if err := validateTemplate("amqp.routing_key", c.AMQP.RoutingKey, false); err != nil {
+			fail("%v", err)
+		}
+	case SinkHTTP:
+		for _, err := range c.HTTP.validate() {
+			fail("%v", err)
+		}
+	case SinkFile:
+		if c.Capture.Path == "" {
+			fail("el sink %s necesita capture.path", SinkFile)
+		}
+	case SinkStdout:
+	default:
+		fail("sink %q no válido: use %s, %s, %s, %s o %s", name, SinkMQTT, SinkAMQP, SinkHTTP, SinkStdout, SinkFile)
+	}
+}
+
+// UsesSink indica si la configuración publica en el sink indicado
+func (c *Config) UsesSink(name string) bool {
+	for _, s := range c.Sinks {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceCount devuelve el total de dispositivos de la flota
+func (c *Config) DeviceCount() int {
+	n := 0
+	for _, g := range c.Fleet {
+		n += g.LastID - g.FirstID + 1
+	}
+	return n
+}
+
+// workerCount aplica los límites del pool al número de dispositivos
+func (c *Config) workerCount() int {
+	n := c.DeviceCount()
+	if n < c.Workers.Min {
+		n = c.Workers.Min
+	}
+	if n > c.Workers.Max {
+		n = c.Workers.Max
+	}
+	return n
+}
+
+// fleetOverlaps compara los rangos de device_id ordenados por first_id: cada grupo sólo
+// puede solaparse con el que llega más lejos de los anteriores. Devuelve {grupo, grupo
+// anterior en la configuración, primer device_id repetido}.
+func fleetOverlaps(fleet []DeviceGroup) [][3]int {
+	var order []int
+	for i, g := range fleet {
+		if g.FirstID > 0 && g.LastID >= g.FirstID {
+			order = append(order, i)
+		}
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return fleet[a].FirstID - fleet[b].FirstID })
+
+	var overlaps [][3]int
+	reach := -1 // grupo con el last_id más alto hasta ahora
+	for _, i := range order {
+		if reach >= 0 && fleet[i].FirstID <= fleet[reach].LastID {
+			overlaps = append(overlaps, [3]int{max(i, reach), min(i, reach), fleet[i].FirstID})
+		}
+		if reach < 0 || fleet[i].LastID > fleet[reach].LastID {
+			reach = i
+		}
+	}
+	return overlaps
+}
+
+func (c *Config) groupProfileName(g DeviceGroup) string {
+	if g.Profile == "" {
+		return DefaultProfile
+	}
+	return g.Profile
+}
+
+// groupOf devuelve el grupo de la flota al que pertenece el device
+func (c *Config) groupOf(deviceID int) (DeviceGroup, bool) {
+	for _, g := range c.Fleet {
+		if deviceID >= g.FirstID && deviceID <= g.LastID {
+			return g, true
+		}
+	}
+	return DeviceGroup{}, false
+}
+
+// sample devuelve un valor uniforme dentro del rango
+func (r Range) sample(rng *rand.Rand) float64 {
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFleetOverlaps(t *testing.T) {
+	group := func(first, last int) DeviceGroup { return DeviceGroup{FirstID: first, LastID: last} }
+	cases := []struct {
+		name  string
+		fleet []DeviceGroup
+		want  [][3]int
+	}{
+		{"contiguos", []DeviceGroup{group(1, 10), group(11, 20)}, nil},
+		{"desordenados", []DeviceGroup{group(11, 20), group(1, 10)}, nil},
+		{"solapados", []DeviceGroup{group(1, 10), group(10, 20)}, [][3]int{{1, 0, 10}}},
+		{"contenido", []DeviceGroup{group(5, 6), group(1, 100)}, [][3]int{{1, 0, 5}}},
+		{"tras uno largo", []DeviceGroup{group(1, 100), group(20, 30), group(50, 60)}, [][3]int{{1, 0, 20}, {2, 0, 50}}},
+		{"rangos enormes", []DeviceGroup{group(1, 1<<40), group(1<<40, 1<<41)}, [][3]int{{1, 0, 1 << 40}}},
+	}
+	for _, c := range cases {
+		if got := fleetOverlaps(c.fleet); !slices.Equal(got, c.want) {
+			t.Errorf("%s: %v, se esperaba %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateLargeFleetIsFast(t *testing.T) {
+	cfg := manualConfig()
+	cfg.Fleet = []DeviceGroup{
+		{FirstID: 1, LastID: 50_000_000, Interval: Duration(time.Minute), Profile: DefaultProfile},
+		{FirstID: 50_000_001, LastID: 100_000_000, Interval: Duration(time.Minute), Profile: DefaultProfile},
+	}
+	start := time.Now()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Validate tardó %s con 10^8 devices", d)
+	}
+}
+
+func TestValidateRejectsManualReplay(t *testing.T) {
+	cfg := manualConfig()
+	cfg.Replay.File = "captura.ndjson"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "manual no admite replay") {
+		t.Fatalf("error %v, se esperaba el rechazo de replay con reloj manual", err)
+	}
+}
+
+func TestValidateManualClockNeedsStep(t *testing.T) {
+	cfg := manualConfig()
+	cfg.Clock.Step = 0
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "clock.step debe ser positivo") {
+		t.Fatalf("error %v, se esperaba el rechazo del reloj manual sin step", err)
+	}
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DatasetConfig es una grabación real de un wearable que reproducen los devices de un grupo
+type DatasetConfig struct {
+	File    string   `json:"file"`    // CSV con cabecera o array JSON; relativo al archivo de configuración
+	Loop    bool     `json:"loop"`    // al terminar vuelve a empezar; si no, el device deja de emitir
+	Stagger Duration `json:"stagger"` // desfase entre devices consecutivos del grupo dentro de la grabación
+}
+
+// nombres de columna (o de campo JSON) admitidos para cada señal
+var datasetColumns = map[string][]string{
+	"time":        {"timestamp", "time", "t"},
+	"hr":          {"hr", "heart_rate", "bpm"},
+	"hr2":         {"hr2", "bpm2"},
+	"spo2":        {"spo2"},
+	"temperature": {"temperature", "temp"},
+	"motion":      {"motion", "moving"},
+}
+
+// Dataset son las muestras de una grabación, con el tiempo relativo a la primera
+type Dataset struct {
+	samples []datasetSample
+	period  time.Duration // duración de una vuelta completa
+}
+
+type datasetSample struct {
+	at time.Duration
+	v  Vitals
+}
+
+// grabaciones ya leídas, por ruta
+var (
+	datasetMu    sync.Mutex
+	datasetCache = make(map[string]*Dataset)
+)
+
+// loadDatasetCached lee la grabación una sola vez aunque la usen muchos devices
+func loadDatasetCached(path string) (*Dataset, error) {
+	datasetMu.Lock()
+	defer datasetMu.Unlock()
+	if ds, ok := datasetCache[path]; ok {
+		return ds, nil
+	}
+	ds, err := LoadDataset(path)
+	if err != nil {
+		return nil, err
+	}
+	datasetCache[path] = ds
+	return ds, nil
+}
+
+// LoadDataset lee una grabación en CSV o JSON (timestamp, HR, SpO2, temperatura y movimiento)
+func LoadDataset(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo abrir el dataset: %w", err)
+	}
+	defer f.Close()
+
+	var rows []map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		rows, err = readDatasetJSON(f)
+	} else {
+		rows, err = readDatasetCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dataset %s: %w", path, err)
+	}
+	ds, err := parseDataset(rows)
+	if err != nil {
+		return nil, fmt.Errorf("dataset %s: %w", path, err)
+	}
+	return ds, nil
+}
+
+// readDatasetCSV devuelve cada fila como columna -> valor
+func readDatasetCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("sin cabecera: %w", err)
+	}
+	var rows []map[string]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(rec[i])
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readDatasetJSON acepta un array de objetos con los mismos nombres que las columnas CSV
+func readDatasetJSON(r io.Reader) ([]map[string]string, error) {
+	var objs []map[string]any
+	if err := json.NewDecoder(r).Decode(&objs); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, len(objs))
+	for i, obj := range objs {
+		row := make(map[string]string, len(obj))
+		for k, v := range obj {
+			if v != nil {
+				row[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// parseDataset convierte las filas en muestras; una celda vacía repite el valor anterior
+func parseDataset(rows []map[string]string) (*Dataset, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("sin muestras")
+	}
+	field := func(row map[string]string, key string) string {
+		for _, name := range datasetColumns[key] {
+			if v := row[name]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	for _, key := range []string{"time", "hr", "spo2", "temperature"} {
+		if field(rows[0], key) == "" {
+			return nil, fmt.Errorf("falta %s en la primera muestra (columnas admitidas: %s)",
+				key, strings.Join(datasetColumns[key], ", "))
+		}
+	}
+
+	ds := &Dataset{samples: make([]datasetSample, 0, len(rows))}
+	var first float64
+	var prev Vitals
+	for i, row := range rows {
+		t, err := parseDatasetTime(field(row, "time"))
+		if err != nil {
+			return nil, fmt.Errorf("muestra %d: %w", i+1, err)
+		}
+		if i == 0 {
+			first = t
+		}
+		v := prev
+		for key, dst := range map[string]*float64{"hr": &v.HeartRate, "spo2": &v.SpO2, "temperature": &v.Temperature} {
+			if s := field(row, key); s != "" {
+				if *dst, err = strconv.ParseFloat(s, 64); err != nil {
+					return nil, fmt.Errorf("muestra %d: %s: %w", i+1, key, err)
+				}
+			}
+		}
+		v.HeartRate2 = v.HeartRate
+		if s := field(row, "hr2"); s != "" {
+			if v.HeartRate2, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("muestra %d: hr2: %w", i+1, err)
+			}
+		}
+		if s := field(row, "motion"); s != "" {
+			if v.Moving, err = parseMotion(s); err != nil {
+				return nil, fmt.Errorf("muestra %d: motion: %w", i+1, err)
+			}
+		}
+
+		at := time.Duration((t - first) * float64(time.Second))
+		if n := len(ds.samples); n > 0 && at < ds.samples[n-1].at {
+			return nil, fmt.Errorf("muestra %d: los timestamps deben estar ordenados", i+1)
+		}
+		ds.samples = append(ds.samples, datasetSample{at: at, v: v})
+		prev = v
+	}
+
+	// una vuelta dura lo grabado más un paso, para que la última muestra no pise a la primera
+	n := len(ds.samples)
+	step := time.Second
+	if n > 1 {
+		step = (ds.samples[n-1].at - ds.samples[0].at) / time.Duration(n-1)
+	}
+	ds.period = ds.samples[n-1].at + max(step, time.Millisecond)
+	return ds, nil
+}
+
+// parseDatasetTime admite RFC3339 o un número de segundos (milisegundos si es epoch en ms)
+func parseDatasetTime(s string) (float64, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("timestamp %q no válido: use RFC3339 o segundos", s)
+	}
+	if n > 1e11 {
+		n /= 1000
+	}
+	return n, nil
+}
+
+// parseMotion admite true/false o un nivel de actividad (distinto de cero = en movimiento)
+func parseMotion(s string) (bool, error) {
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false, fmt.Errorf("%q no es booleano ni numérico", s)
+	}
+	return n != 0, nil
+}
+
+// datasetCursor recorre una grabación en tiempo real desde que el device empieza a emitir
+type datasetCursor struct {
+	ds     *Dataset
+	loop   bool
+	offset time.Duration
+	start  time.Time
+}
+
+func newDatasetCursor(ds *Dataset, loop bool, offset time.Duration) *datasetCursor {
+	return &datasetCursor{ds: ds, loop: loop, offset: offset}
+}
+
+// Next interpola la lectura del instante now; la última muestra dura un paso más y,
+// sin loop, después devuelve ok=false
+func (c *datasetCursor) Next(now time.Time) (Vitals, bool) {
+	if c.start.IsZero() {
+		c.start = now
+	}
+	pos := now.Sub(c.start) + c.offset
+	if c.loop {
+		pos %= c.ds.period
+	} else if pos >= c.ds.period {
+		return Vitals{}, false
+	}
+
+	s := c.ds.samples
+	i := sort.Search(len(s), func(i int) bool { return s[i].at > pos }) - 1
+	if i < 0 {
+		return s[0].v, true
+	}
+	if i == len(s)-1 || s[i+1].at == s[i].at {
+		return s[i].v, true
+	}
+	f := float64(pos-s[i].at) / float64(s[i+1].at-s[i].at)
+	a, b := s[i].v, s[i+1].v
+	return Vitals{
+		HeartRate:   lerp(a.HeartRate, b.HeartRate, f),
+		HeartRate2:  lerp(a.HeartRate2, b.HeartRate2, f),
+		SpO2:        lerp(a.SpO2, b.SpO2, f),
+		Temperature: lerp(a.Temperature, b.Temperature, f),
+		Moving:      a.Moving,
+	}, true
+}
+
+func lerp(a, b, f float64) float64 {
+	return a + (b-a)*f
+}
+
+// Samples devuelve el número de muestras de la grabación
+func (d *Dataset) Samples() int {
+	return len(d.samples)
+}
+
+// Span devuelve la duración de una vuelta completa
+func (d *Dataset) Span() time.Duration {
+	return d.period
+}
+
+// loadDatasets lee de antemano las grabaciones que usa la flota para fallar antes de arrancar
+func (c *Config) loadDatasets() error {
+	for _, g := range c.Fleet {
+		if groupSourceName(g) != SourceDataset {
+			continue
+		}
+		if _, err := loadDatasetCached(c.Datasets[g.Dataset].File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"simulator/src/models"
+)
+
+// clientes MQTT propios de cada device cuando broker.per_device_clients está activo
+var (
+	deviceMu      sync.Mutex
+	deviceClients = make(map[int]*mqttConn)
+
+	// fallos de conexión por device; sobreviven al cierre de los clientes para el resumen final
+	failuresMu     sync.Mutex
+	deviceFailures = make(map[int]*ConnFailure)
+
+	connectFailures atomic.Uint64
+	connectionsLost atomic.Uint64
+)
+
+// ConnFailure resume los problemas de conexión de un device
+type ConnFailure struct {
+	DeviceID  int
+	Failures  uint64 // intentos de conexión fallidos
+	Lost      uint64 // conexiones ya establecidas que se cayeron
+	LastError string
+}
+
+// deviceConn devuelve el cliente del device, creándolo (sin conectar) la primera vez
+func deviceConn(deviceID, userID int) *mqttConn {
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	if c := deviceClients[deviceID]; c != nil {
+		return c
+	}
+	c := newDeviceConn(deviceID, userID)
+	deviceClients[deviceID] = c
+	return c
+}
+
+// newDeviceConn crea el cliente <client_id>-<device_id> con su Last Will
+func newDeviceConn(deviceID, userID int) *mqttConn {
+	cfg := brokerCfg
+	will := cfg.Will
+	c := &mqttConn{offline: newOfflineQueue(cfg.OfflineBuffer), deviceID: deviceID}
+	device := &models.Message{DeviceId: deviceID, UserID: userID}
+	if will.Topic != "" {
+		c.status = renderTemplate(will.Topic, device)
+	}
+
+	opts, err := newClientOptions(cfg, fmt.Sprintf("%s-%d", cfg.ClientID, deviceID), device)
+	if err != nil {
+		// sin opciones válidas no se puede conectar; dial lo registra como fallo
+		c.setupErr = err
+		opts = mqtt.NewClientOptions()
+	}
+	// dial gestiona la conexión y las reconexiones para limitar el ritmo y contar los fallos
+	opts.SetConnectRetry(false)
+	opts.SetAutoReconnect(false)
+	if c.status != "" {
+		opts.SetWill(c.status, will.Payload, will.QoS, will.Retain)
+	}
+	opts.SetOnConnectHandler(func(cl mqtt.Client) {
+		if c.status != "" && will.OnlinePayload != "" {
+			cl.Publish(c.status, will.QoS, will.Retain, will.OnlinePayload)
+		}
+		go c.replay()
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		recordConnFailure(deviceID, true, err)
+		// la reconexión también espera su turno: una caída del broker no debe reconectar la flota de golpe
+		c.dialing.Store(false)
+		c.dial(c.dialCtx, c.limiter)
+	})
+
+	c.client = mqtt.NewClient(opts)
+	return c
+}
+
+// dial conecta el cliente en segundo plano y reintenta hasta lograrlo; cada intento
+// espera su turno en limiter para no provocar avalanchas de conexiones
+func (c *mqttConn) dial(ctx context.Context, limiter *rateLimiter) {
+	if !c.dialing.CompareAndSwap(false, true) {
+		return
+	}
+	c.dialCtx, c.limiter = ctx, limiter
+	if c.setupErr != nil {
+		recordConnFailure(c.deviceID, false, c.setupErr)
+		return
+	}
+	go func() {
+		for {
+			if limiter.wait(ctx) != nil {
+				return
+			}
+			err := waitToken(c.client.Connect())
+			if err == nil {
+				return
+			}
+			recordConnFailure(c.deviceID, false, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(brokerCfg.ConnectRetryInterval)):
+			}
+		}
+	}()
+}
+
+// dialFleet abre de antemano los clientes de todos los devices de la flota
+func dialFleet(ctx context.Context, fleet []DeviceGroup, limiter *rateLimiter) {
+	for _, g := range fleet {
+		for id := g.FirstID; id <= g.LastID; id++ {
+			if ctx.Err() != nil {
+				return
+			}
+			deviceConn(id, id+g.UserOffset).dial(ctx, limiter)
+		}
+	}
+}
+
+// recordConnFailure registra un fallo de conexión (lost=false) o una caída (lost=true)
+func recordConnFailure(deviceID int, lost bool, err error) {
+	failuresMu.Lock()
+	f := deviceFailures[deviceID]
+	if f == nil {
+		f = &ConnFailure{DeviceID: deviceID}
+		deviceFailures[deviceID] = f
+	}
+	if lost {
+		f.Lost++
+	} else {
+		f.Failures++
+	}
+	if err != nil {
+		f.LastError = err.Error()
+	}
+	failuresMu.Unlock()
+
+	if lost {
+		connectionsLost.Add(1)
+		fmt.Printf("Device %d: conexión con el broker perdida: %v\n", deviceID, err)
+	} else {
+		connectFailures.Add(1)
+		if LogMessages {
+			fmt.Printf("Device %d: no se pudo conectar: %v\n", deviceID, err)
+		}
+	}
+}
+
+// ConnFailures devuelve los devices con problemas de conexión, los peores primero
+func ConnFailures() []ConnFailure {
+	failuresMu.Lock()
+	list := make([]ConnFailure, 0, len(deviceFailures))
+	for _, f := range deviceFailures {
+		list = append(list, *f)
+	}
+	failuresMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].Failures+list[i].Lost, list[j].Failures+list[j].Lost
+		if a != b {
+			return a > b
+		}
+		return list[i].DeviceID < list[j].DeviceID
+	})
+	return list
+}
+
+// resetConnFailures borra los fallos de la simulación anterior
+func resetConnFailures() {
+	failuresMu.Lock()
+	deviceFailures = make(map[int]*ConnFailure)
+	failuresMu.Unlock()
+	connectFailures.Store(0)
+	connectionsLost.Store(0)
+}
+
+// deviceConns devuelve los clientes de device abiertos
+func deviceConns() []*mqttConn {
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	conns := make([]*mqttConn, 0, len(deviceClients))
+	for _, c := range deviceClients {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// connectedDevices cuenta los clientes de device con la conexión abierta
+func connectedDevices() int {
+	n := 0
+	for _, c := range deviceConns() {
+		if c.client.IsConnectionOpen() {
+			n++
+		}
+	}
+	return n
+}
+
+// disconnectDevices cierra los clientes de device. Una desconexión limpia descarta el
+// Last Will, así que antes se publica el mensaje offline de forma explícita.
+func disconnectDevices() {
+	deviceMu.Lock()
+	conns := deviceClients
+	deviceClients = make(map[int]*mqttConn)
+	deviceMu.Unlock()
+
+	will := brokerCfg.Will
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.status != "" && c.client.IsConnectionOpen() {
+				waitToken(c.client.Publish(c.status, will.QoS, will.Retain, will.Payload))
+			}
+			c.client.Disconnect(250)
+		}()
+	}
+	wg.Wait()
+}
+
+// rateLimiter entrega un permiso cada cierto tiempo; nil no limita
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter admite perSecond permisos por segundo; 0 o negativo = sin límite
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.ticker.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
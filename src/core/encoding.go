@@ -0,0 +1,208 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"simulator/src/models"
+)
+
+// codificaciones del payload
+const (
+	EncodingJSON    = "json"
+	EncodingCBOR    = "cbor"
+	EncodingMsgPack = "msgpack"
+	EncodingESP32   = "esp32" // trama binaria fija propuesta para el firmware (ver appendESP32)
+)
+
+var encodingContentTypes = map[string]string{
+	EncodingJSON:    "application/json",
+	EncodingCBOR:    "application/cbor",
+	EncodingMsgPack: "application/msgpack",
+	EncodingESP32:   "application/octet-stream",
+}
+
+// appendCBOR codifica v en CBOR (RFC 8949) con los tipos que producen los esquemas
+func appendCBOR(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case int64:
+		if v < 0 {
+			return cborHead(b, 1, uint64(-1-v)), nil
+		}
+		return cborHead(b, 0, uint64(v)), nil
+	case uint64:
+		return cborHead(b, 0, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v)), nil
+	case string:
+		return append(cborHead(b, 3, uint64(len(v))), v...), nil
+	case object:
+		b = cborHead(b, 5, uint64(len(v)))
+		for _, f := range v {
+			b = append(cborHead(b, 3, uint64(len(f.key))), f.key...)
+			var err error
+			if b, err = appendCBOR(b, f.value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("CBOR: tipo %T no soportado", v)
+}
+
+// cborHead escribe el tipo mayor y su argumento con la longitud mínima
+func cborHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, m|27), n)
+}
+
+// appendMsgPack codifica v en MessagePack con el formato más corto de cada tipo
+func appendMsgPack(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int64:
+		if v >= 0 {
+			return msgpackUint(b, uint64(v)), nil
+		}
+		switch {
+		case v >= -32:
+			return append(b, byte(v)), nil
+		case v >= math.MinInt8:
+			return append(b, 0xd0, byte(v)), nil
+		case v >= math.MinInt16:
+			return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v)), nil
+		case v >= math.MinInt32:
+			return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v)), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v)), nil
+	case uint64:
+		return msgpackUint(b, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			b = append(b, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+		default:
+			b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+		}
+		return append(b, v...), nil
+	case object:
+		b = msgpackLen(b, len(v), 0x80, 0xde)
+		for _, f := range v {
+			var err error
+			if b, err = appendMsgPack(b, f.key); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgPack(b, f.value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("MessagePack: tipo %T no soportado", v)
+}
+
+func msgpackUint(b []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
+
+// msgpackLen escribe la cabecera de un array o mapa: fix (hasta 15), 16 o 32 bits
+func msgpackLen(b []byte, n int, fix, len16 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, len16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, len16+1), uint32(n))
+}
+
+// tamaño de la trama ESP32
+const esp32FrameSize = 27
+
+// appendESP32 escribe una trama binaria fija en little endian. Es una disposición propuesta
+// para el firmware del guante, que todavía no define la suya; si llega a hacerlo, hay que
+// ajustarla a esa trama:
+//
+//	0  u8   versión de la trama (1)
+//	1  u32  device_id
+//	5  u32  user_id
+//	9  u8   bpm
+//	10 u8   bpm2
+//	11 u8   spo2
+//	12 i16  temperatura en centésimas de °C
+//	14 u8   flags (bit 0 = en movimiento)
+//	15 u64  timestamp en epoch ms (0 sin payload v2)
+//	23 u32  seq (0 sin payload v2)
+func appendESP32(b []byte, msg *models.Message) []byte {
+	var flags byte
+	if msg.Moving {
+		flags |= 0x01
+	}
+	b = append(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, uint32(msg.DeviceId))
+	b = binary.LittleEndian.AppendUint32(b, uint32(msg.UserID))
+	b = append(b, clampByte(msg.Bpm), clampByte(msg.Bpm2), clampByte(msg.Spo2))
+	b = binary.LittleEndian.AppendUint16(b, uint16(int16(math.Round(msg.Temperature*100))))
+	b = append(b, flags)
+	b = binary.LittleEndian.AppendUint64(b, uint64(timestampMillis(msg.Timestamp)))
+	return binary.LittleEndian.AppendUint32(b, uint32(msg.Seq))
+}
+
+func clampByte(n int) byte {
+	return byte(min(max(n, 0), math.MaxUint8))
+}
+
+// timestampMillis convierte el timestamp de payload v2 a epoch ms; 0 si no hay
+func timestampMillis(ts any) int64 {
+	switch ts := ts.(type) {
+	case int64:
+		return ts
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t.UnixMilli()
+		}
+	}
+	return 0
+}
@@ -2,7 +2,7 @@ package core
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -20,34 +20,65 @@ var (
 	simCancel context.CancelFunc
 	simMu     sync.Mutex
 	simActive bool
+	simRun    int
+	simDone   chan struct{}
 
-	// canal público que notifica a la GUI cuando un mensaje se publicó OK (envía DeviceID)
+	// canal de la ejecución en curso que notifica a la GUI cuando un mensaje se publicó OK
+	// (envía DeviceID); protegido por simMu, mejor leerlo con GetSendOK
 	SendOK chan int
 )
 
-// simulateDevice produce measurements periodicamente para un device y las envia al canal jobs.
-func simulateDevice(ctx context.Context, deviceID, userID int, interval time.Duration, jobs chan<- models.DeviceData, prodWG *sync.WaitGroup) {
-	prodWG.Add(1)
-	ticker := time.NewTicker(interval)
-	go func() {}()
-	namedLoopDevice(ctx, deviceID, userID, ticker, jobs, prodWG)
+// deviceJob es una lectura producida por un dispositivo pendiente de publicar
+type deviceJob struct {
+	device models.DeviceData
+	vitals Vitals
+	meta   messageMeta
 }
 
-func namedLoopDevice(ctx context.Context, deviceID, userID int, ticker *time.Ticker, jobs chan<- models.DeviceData, prodWG *sync.WaitGroup) {
+// deviceLoop es lo que necesita el productor de un device
+type deviceLoop struct {
+	device   models.DeviceData
+	interval time.Duration
+	start    time.Time
+	end      time.Time // fin de la duración en tiempo simulado; cero = sin límite
+	source   SensorSource
+	stamp    *stamper
+}
+
+// simulateDevice lanza el productor que envía periódicamente las lecturas de un device a jobs.
+// El ticker se crea antes de lanzarlo para que un reloj manual no avance sin contar con él.
+// stop detiene la espera de nuevas lecturas; ctx cancela también el envío de la que está en curso.
+func simulateDevice(ctx, stop context.Context, clk Clock, loop deviceLoop, jobs chan<- deviceJob, prodWG *sync.WaitGroup) {
+	loop.start = clk.Now()
+	ticker := clk.NewTicker(loop.interval)
+	go namedLoopDevice(ctx, stop, ticker, loop, jobs, prodWG)
+}
+
+// namedLoopDevice es dueño de la fuente de lecturas del device, así forman una serie continua;
+// termina cuando la fuente se agota (una grabación sin loop) o se alcanza el final de la duración
+func namedLoopDevice(ctx, stop context.Context, ticker Ticker, loop deviceLoop, jobs chan<- deviceJob, prodWG *sync.WaitGroup) {
 	defer prodWG.Done()
 	defer ticker.Stop()
 
+	// cada lectura usa el instante nominal de su tick y no el real, así el jitter
+	// del ticker no altera la serie y la misma semilla la repite
 	for {
 		select {
-		case <-ctx.Done():
+		case <-stop.Done():
 			return
-		case <-ticker.C:
-			dev := models.DeviceData{
-				IdDevice: deviceID,
-				IdUser:   userID,
+		case tick := <-ticker.C():
+			n := (tick.Sub(loop.start) + loop.interval/2) / loop.interval
+			at := loop.start.Add(n * loop.interval)
+			if !loop.end.IsZero() && !at.Before(loop.end) {
+				return
 			}
+			vitals, ok := loop.source.Next(at)
+			if !ok {
+				return
+			}
+			job := deviceJob{device: loop.device, vitals: vitals, meta: loop.stamp.next(at)}
 			select {
-			case jobs <- dev:
+			case jobs <- job:
 			case <-ctx.Done():
 				return
 			}
@@ -56,18 +87,19 @@ func namedLoopDevice(ctx context.Context, deviceID, userID int, ticker *time.Tic
 }
 
 // worker consume jobs y genera mensajes simulados
-func worker(ctx context.Context, jobs <-chan models.DeviceData, results chan<- *models.Message, workerWG *sync.WaitGroup) {
+func worker(ctx context.Context, clk Clock, rng *rand.Rand, jobs <-chan deviceJob, results chan<- *models.Message, workerWG *sync.WaitGroup) {
 	defer workerWG.Done()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case dev, ok := <-jobs:
+		case job, ok := <-jobs:
 			if !ok {
 				return
 			}
-			msg := GenerateSensorData(dev)
-			sleepRandomDelay()
+			msg := buildMessage(job.device, job.vitals)
+			job.meta.apply(msg)
+			sleepRandomDelay(ctx, clk, rng)
 			select {
 			case results <- msg:
 			case <-ctx.Done():
@@ -77,14 +109,13 @@ func worker(ctx context.Context, jobs <-chan models.DeviceData, results chan<- *
 	}
 }
 
-func sleepRandomDelay() {
-	ms := rand.Intn(200)
-	time.Sleep(time.Duration(ms) * time.Millisecond)
+func sleepRandomDelay(ctx context.Context, clk Clock, rng *rand.Rand) {
+	ms := rng.Intn(200)
+	clk.Sleep(ctx, time.Duration(ms)*time.Millisecond)
 }
 
-// publisher publica y notifica a UI
-func publisher(ctx context.Context, results <-chan *models.Message, pubWG *sync.WaitGroup) {
-	pubWG.Add(1)
+// publisher entrega cada mensaje al sink y notifica a UI por el canal sendOK de su ejecución
+func publisher(ctx context.Context, results <-chan *models.Message, sink Sink, sendOK chan<- int, pubWG *sync.WaitGroup) {
 	defer pubWG.Done()
 
 	for {
@@ -96,107 +127,274 @@ func publisher(ctx context.Context, results <-chan *models.Message, pubWG *sync.
 				return
 			}
 
-			dataJSON, err := json.Marshal(msg)
+			err := sink.Publish(ctx, msg)
+			if errors.Is(err, errBuffered) {
+				// el sink lo anotará con settleFrom al entregarlo
+				continue
+			}
+			countOutcome(err)
 			if err != nil {
-				log.Println("Error al serializar mensaje simulado:", err)
+				log.Printf("Mensaje del device %d descartado: %v", msg.DeviceId, err)
 				continue
 			}
 
-			PublishData(string(dataJSON))
-
 			// Notificar UI
-			if SendOK != nil {
-				select {
-				case SendOK <- msg.DeviceId:
-				default:
-				}
+			select {
+			case sendOK <- msg.DeviceId:
+			default:
 			}
 		}
 	}
 }
 
+// countOutcome anota en las estadísticas el resultado final de un mensaje; es la única
+// que toca los contadores de publicados y fallidos
+func countOutcome(err error) {
+	if err != nil {
+		failedCount.Add(1)
+		return
+	}
+	publishedCount.Add(1)
+}
+
 // startWorkers lanza el pool de workers
-func startWorkers(ctx context.Context, workerCount int, jobs <-chan models.DeviceData, results chan<- *models.Message, workerWG *sync.WaitGroup) {
+func startWorkers(ctx context.Context, clk Clock, seed int64, workerCount int, jobs <-chan deviceJob, results chan<- *models.Message, workerWG *sync.WaitGroup) {
 	for i := 0; i < workerCount; i++ {
-		go worker(ctx, jobs, results, workerWG)
+		workerWG.Add(1)
+		go worker(ctx, clk, newStream(seed, workerStreamBase+uint64(i)), jobs, results, workerWG)
 	}
 }
 
-// cleanupHandler gestiona el shutdown ordenado
-func cleanupHandler(ctx context.Context, jobs chan models.DeviceData, results chan *models.Message, prodWG *sync.WaitGroup, workerWG *sync.WaitGroup, pubWG *sync.WaitGroup) {
-	<-ctx.Done()
+// cleanupHandler gestiona el shutdown ordenado. Los productores simulados sólo paran al
+// cancelar ctx; una reproducción termina sola y entonces el pipeline se vacía por completo.
+func cleanupHandler(ctx context.Context, run int, stopProducers context.CancelFunc, jobs chan deviceJob, results chan *models.Message, prodWG *sync.WaitGroup, workerWG *sync.WaitGroup, pubWG *sync.WaitGroup, sink Sink, sendOK chan int, done chan struct{}) {
+	defer close(done)
+
+	produced := make(chan struct{})
+	go func() {
+		prodWG.Wait()
+		close(produced)
+	}()
+	finished := false
+	select {
+	case <-ctx.Done():
+	case <-produced:
+		finished = ctx.Err() == nil
+	}
 
 	prodWG.Wait()
+	stopProducers()
 	close(jobs)
 
 	workerWG.Wait()
 	close(results)
 
 	pubWG.Wait()
+	if err := sink.Close(); err != nil {
+		log.Println("Error al cerrar el sink:", err)
+	}
 
-	if SendOK != nil {
-		close(SendOK)
+	// el canal es de esta ejecución: ninguna otra puede haber empezado antes de cerrar done
+	simMu.Lock()
+	if SendOK == sendOK {
 		SendOK = nil
 	}
+	simMu.Unlock()
+	close(sendOK)
+	if finished {
+		finishRun(run)
+	}
+}
+
+// finishRun cierra una ejecución cuyos productores acabaron por sí solos o por su duración
+func finishRun(run int) {
+	simMu.Lock()
+	defer simMu.Unlock()
+	if simRun != run {
+		return
+	}
+	if simActive {
+		log.Println("Todos los productores terminaron: fin de la reproducción o de los datasets")
+		simActive = false
+	}
+	simCancel()
 }
 
-// GetSendOK expone el canal a la UI
+// GetSendOK expone a la UI el canal de la ejecución en curso
 func GetSendOK() <-chan int {
+	simMu.Lock()
+	defer simMu.Unlock()
 	return SendOK
 }
 
-// StartSimulation inicia toda la simulación
-func StartSimulation(interval time.Duration) error {
+// StartSimulation inicia toda la simulación descrita por cfg, publicando en los sinks configurados.
+// cfg debe haber pasado Validate.
+func StartSimulation(cfg *Config) error {
+	if IsSimulationActive() {
+		return fmt.Errorf("simulación ya está activada")
+	}
+
+	sink, err := NewSink(cfg)
+	if err != nil {
+		return err
+	}
+	if err := StartSimulationWithSink(cfg, sink); err != nil {
+		sink.Close()
+		return err
+	}
+	return nil
+}
+
+// StartSimulationWithSink inicia la simulación publicando en sink, que se cierra al terminar.
+// cfg debe haber pasado Validate.
+func StartSimulationWithSink(cfg *Config, sink Sink) error {
+	var records []CaptureRecord
+	devices := cfg.DeviceCount()
+	if cfg.Replay.File != "" {
+		var err error
+		if records, err = LoadCapture(cfg.Replay.File); err != nil {
+			return err
+		}
+		devices = replayDevices(records, cfg.Replay)
+	}
+
 	simMu.Lock()
 	defer simMu.Unlock()
 
 	if simActive {
 		return fmt.Errorf("simulación ya está activada")
 	}
-
-	if client == nil || !client.IsConnected() {
-		return fmt.Errorf("MQTT no conectado - conecta primero")
+	// la anterior puede seguir vaciando el pipeline tras vencer su duración o detenerse;
+	// hasta que cierre simDone sigue usando los contadores y su canal SendOK
+	if simDone != nil {
+		select {
+		case <-simDone:
+		default:
+			return fmt.Errorf("la simulación anterior todavía está terminando de publicar")
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	simCancel = cancel
 	simActive = true
+	simRun++
+	simDone = make(chan struct{})
+	seed := chooseSeed(cfg.Seed)
+	runSeed.Store(seed)
+	clk := newClock(cfg.Clock)
+	setClock(clk)
+	resetStats(devices, clk.Now())
+	// la deriva circadiana depende de la hora simulada: sin el mismo inicio la semilla no basta
+	log.Printf("Semilla de la simulación: %d, inicio %s (repetible con seed/-seed y clock.start/-start)",
+		seed, clk.Now().Format(time.RFC3339))
+	if cfg.Clock.Mode != "" && cfg.Clock.Mode != ClockReal {
+		log.Printf("Reloj %s", cfg.Clock.Mode)
+	}
+	// al vencer la duración sólo paran los productores; lo ya leído se termina de publicar
+	prodCtx, stopProducers := context.WithCancel(ctx)
+	if cfg.Duration > 0 {
+		// el temporizador se crea aquí, antes de que nada pueda avanzar un reloj manual
+		go expireSimulation(ctx, clk.After(time.Duration(cfg.Duration)), simRun, stopProducers)
+	}
 
-	jobs := make(chan models.DeviceData, 1000)
+	jobs := make(chan deviceJob, 1000)
 	results := make(chan *models.Message, 1000)
 
-	SendOK = make(chan int, 1024)
+	sendOK := make(chan int, 1024)
+	SendOK = sendOK
 
 	var prodWG sync.WaitGroup
 	var workerWG sync.WaitGroup
 	var pubWG sync.WaitGroup
 
-	// 
-	numDevices := GlobalDeviceCount
-
-	workerCount := numDevices
-	if workerCount < 4 {
-		workerCount = 4
+	startWorkers(ctx, clk, seed, cfg.workerCount(), jobs, results, &workerWG)
+	outgoing := results
+	if cfg.Faults.Enabled() {
+		// la etapa de fallos se interpone entre los workers (o la reproducción) y el publisher
+		log.Printf("Inyección de fallos activa: %s", cfg.Faults)
+		faulted := make(chan *models.Message, 1000)
+		pubWG.Add(1)
+		go newFaultInjector(cfg.Faults, clk, seed).run(ctx, results, faulted, &pubWG)
+		outgoing = faulted
+	}
+	pubWG.Add(1)
+	go publisher(ctx, outgoing, sink, sendOK, &pubWG)
+
+	if records != nil {
+		// la reproducción entrega mensajes ya armados directamente al publisher
+		log.Printf("Reproduciendo %d mensajes de %s a velocidad %gx", len(records), cfg.Replay.File, cfg.Replay.Speed)
+		prodWG.Add(1)
+		go replayCapture(prodCtx, records, cfg.Replay, results, &prodWG)
+	} else {
+		if cfg.Scenario != nil {
+			log.Printf("Escenario %q activo con %d eventos", cfg.Scenario.Name, len(cfg.Scenario.Events))
+		}
+		if len(cfg.SensorFaults) > 0 {
+			log.Printf("%d averías de sensor programadas", len(cfg.SensorFaults))
+		}
+		for _, g := range cfg.Fleet {
+			if groupSourceName(g) == SourceDataset {
+				log.Printf("Devices %d-%d reproducen el dataset %q", g.FirstID, g.LastID, g.Dataset)
+			}
+		}
+		startDeviceProducers(ctx, prodCtx, cfg, clk, seed, jobs, &prodWG)
 	}
-	if workerCount > 500 {
-		workerCount = 500
+	if m, ok := clk.(*ManualClock); ok {
+		go driveClock(prodCtx, m, time.Duration(cfg.Clock.Step))
 	}
+	go cleanupHandler(ctx, simRun, stopProducers, jobs, results, &prodWG, &workerWG, &pubWG, sink, sendOK, simDone)
 
-	startWorkers(ctx, workerCount, jobs, results, &workerWG)
-	go publisher(ctx, results, &pubWG)
+	return nil
+}
 
-	startDeviceProducers(ctx, numDevices, interval, jobs, &prodWG)
-	go cleanupHandler(ctx, jobs, results, &prodWG, &workerWG, &pubWG)
+// startDeviceProducers crea los dispositivos de cada grupo de la flota
+func startDeviceProducers(ctx, stop context.Context, cfg *Config, clk Clock, seed int64, jobs chan<- deviceJob, prodWG *sync.WaitGroup) {
+	var end time.Time
+	if cfg.Duration > 0 {
+		end = clk.Now().Add(time.Duration(cfg.Duration))
+	}
+	for _, group := range cfg.Fleet {
+		for i := group.FirstID; i <= group.LastID; i++ {
+			deviceID := i
+			userID := i + group.UserOffset
+			source, err := cfg.newSource(group, deviceID, seed, clk.Now())
+			if err != nil {
+				log.Printf("Device %d sin fuente de lecturas: %v", deviceID, err)
+				continue
+			}
+			prodWG.Add(1)
+			simulateDevice(ctx, stop, clk, deviceLoop{
+				device:   models.DeviceData{IdDevice: deviceID, IdUser: userID},
+				interval: time.Duration(group.Interval),
+				end:      end,
+				source:   source,
+				stamp:    newStamper(cfg.Payload, seed, deviceID),
+			}, jobs, prodWG)
+		}
+	}
+}
 
-	return nil
+// expireSimulation detiene los productores cuando vence la duración en tiempo simulado;
+// el pipeline se vacía y cleanupHandler cierra la ejecución
+func expireSimulation(ctx context.Context, expired <-chan time.Time, run int, stopProducers context.CancelFunc) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-expired:
+	}
+	simMu.Lock()
+	if simRun == run && simActive {
+		log.Println("Duración de la simulación cumplida, deteniendo")
+		simActive = false
+	}
+	simMu.Unlock()
+	stopProducers()
 }
 
-// startDeviceProducers crea los dispositivos
-func startDeviceProducers(ctx context.Context, numDevices int, interval time.Duration, jobs chan<- models.DeviceData, prodWG *sync.WaitGroup) {
-	for i := 2; i <= numDevices+1; i++ {
-		deviceID := i
-		userID := i
-		go simulateDevice(ctx, deviceID, userID, interval, jobs, prodWG)
+// driveClock avanza el reloj manual paso a paso tan rápido como el pipeline acepta las lecturas
+func driveClock(ctx context.Context, clk *ManualClock, step time.Duration) {
+	for ctx.Err() == nil {
+		clk.Advance(step)
 	}
 }
 
@@ -214,6 +412,13 @@ func StopSimulation() {
 	simActive = false
 }
 
+// SimulationDone devuelve un canal que se cierra cuando la última simulación terminó de vaciarse
+func SimulationDone() <-chan struct{} {
+	simMu.Lock()
+	defer simMu.Unlock()
+	return simDone
+}
+
 // IsSimulationActive indica si está activa
 func IsSimulationActive() bool {
 	simMu.Lock()
@@ -0,0 +1,439 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simulator/src/models"
+)
+
+// fallos que puede inyectar la etapa entre los workers y el publisher
+const (
+	FaultDrop       = "drop"
+	FaultDuplicate  = "duplicate"
+	FaultReorder    = "reorder"
+	FaultTruncate   = "truncate"
+	FaultCorrupt    = "corrupt"
+	FaultNull       = "null"
+	FaultOutOfRange = "out_of_range"
+	FaultLatency    = "latency"
+)
+
+// FaultConfig inyecta tráfico defectuoso para probar la ingesta; las probabilidades son
+// por mensaje, de 0 a 1, y cada fallo inyectado queda en el log con la etiqueta [fallo:<tipo>]
+type FaultConfig struct {
+	Drop          float64      `json:"drop"`
+	Duplicate     float64      `json:"duplicate"`
+	Reorder       float64      `json:"reorder"`
+	ReorderWindow int          `json:"reorder_window"` // mensajes que pueden adelantar a uno retenido
+	Truncate      float64      `json:"truncate"`
+	Corrupt       float64      `json:"corrupt"`
+	Null          float64      `json:"null"`         // un campo a null
+	OutOfRange    float64      `json:"out_of_range"` // un valor imposible para el sensor
+	Latency       LatencyFault `json:"latency"`
+}
+
+// LatencyFault son ráfagas en las que cada mensaje sale con un retraso añadido
+type LatencyFault struct {
+	Probability float64  `json:"probability"` // de que un mensaje inicie una ráfaga
+	Duration    Duration `json:"duration"`    // en tiempo simulado
+	Delay       Duration `json:"delay"`
+}
+
+// Enabled indica si hay algún fallo configurado
+func (f FaultConfig) Enabled() bool {
+	return f.Drop > 0 || f.Duplicate > 0 || f.Reorder > 0 || f.Truncate > 0 || f.Corrupt > 0 ||
+		f.Null > 0 || f.OutOfRange > 0 || f.Latency.Probability > 0
+}
+
+// payloadFaults indica si hay fallos que alteran el payload serializado
+func (f FaultConfig) payloadFaults() bool {
+	return f.Truncate > 0 || f.Corrupt > 0 || f.Null > 0
+}
+
+func (f FaultConfig) validate() []error {
+	var errs []error
+	for _, p := range []struct {
+		name string
+		v    float64
+	}{
+		{FaultDrop, f.Drop}, {FaultDuplicate, f.Duplicate}, {FaultReorder, f.Reorder},
+		{FaultTruncate, f.Truncate}, {FaultCorrupt, f.Corrupt}, {FaultNull, f.Null},
+		{FaultOutOfRange, f.OutOfRange}, {"latency.probability", f.Latency.Probability},
+	} {
+		if p.v < 0 || p.v > 1 {
+			errs = append(errs, fmt.Errorf("faults.%s (%g) debe estar entre 0 y 1", p.name, p.v))
+		}
+	}
+	if f.Reorder > 0 && f.ReorderWindow < 1 {
+		errs = append(errs, fmt.Errorf("faults.reorder_window debe ser al menos 1 (es %d)", f.ReorderWindow))
+	}
+	if f.Latency.Probability > 0 && (f.Latency.Duration <= 0 || f.Latency.Delay <= 0) {
+		errs = append(errs, fmt.Errorf("faults.latency requiere duration y delay positivos"))
+	}
+	return errs
+}
+
+// String resume los fallos activos para el log ("drop=0.05 duplicate=0.01")
+func (f FaultConfig) String() string {
+	var parts []string
+	add := func(name string, p float64) {
+		if p > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%g", name, p))
+		}
+	}
+	add(FaultDrop, f.Drop)
+	add(FaultDuplicate, f.Duplicate)
+	if f.Reorder > 0 {
+		parts = append(parts, fmt.Sprintf("%s=%g (ventana %d)", FaultReorder, f.Reorder, f.ReorderWindow))
+	}
+	add(FaultTruncate, f.Truncate)
+	add(FaultCorrupt, f.Corrupt)
+	add(FaultNull, f.Null)
+	add(FaultOutOfRange, f.OutOfRange)
+	if f.Latency.Probability > 0 {
+		parts = append(parts, fmt.Sprintf("%s=%g (+%s durante %s)", FaultLatency, f.Latency.Probability,
+			time.Duration(f.Latency.Delay), time.Duration(f.Latency.Duration)))
+	}
+	return strings.Join(parts, " ")
+}
+
+var (
+	faultCountsMu sync.Mutex
+	faultCounts   = make(map[string]uint64)
+)
+
+func countFault(kind string) {
+	faultCountsMu.Lock()
+	faultCounts[kind]++
+	faultCountsMu.Unlock()
+}
+
+// FaultCounts devuelve cuántos fallos de cada tipo se inyectaron en la ejecución
+func FaultCounts() map[string]uint64 {
+	faultCountsMu.Lock()
+	defer faultCountsMu.Unlock()
+	counts := make(map[string]uint64, len(faultCounts))
+	for kind, n := range faultCounts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+func resetFaultCounts() {
+	faultCountsMu.Lock()
+	faultCounts = make(map[string]uint64)
+	faultCountsMu.Unlock()
+}
+
+// FormatFaults lista los fallos por tipo en orden alfabético ("drop=3 null=1")
+func FormatFaults(counts map[string]uint64) string {
+	if len(counts) == 0 {
+		return "ninguno"
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%s=%d", kind, counts[kind])
+	}
+	return strings.Join(parts, " ")
+}
+
+// faultInjector es la etapa que altera el tráfico entre los workers y el publisher. Los
+// fallos de cada mensaje salen de su propia clave (semilla, device_id y seq), así la misma
+// semilla afecta a los mismos mensajes aunque los workers los entreguen en otro orden.
+type faultInjector struct {
+	cfg      FaultConfig
+	clk      Clock
+	seed     int64
+	burstEnd time.Time // fin de la ráfaga de latencia en curso
+	held     []heldMessage
+	delayed  []delayedMessage // retrasados por la latencia, por orden de salida
+	timer    <-chan time.Time // vence cuando toca entregar el primero de delayed
+}
+
+// delayedMessage es un mensaje que la ráfaga de latencia retiene hasta at
+type delayedMessage struct {
+	msg *models.Message
+	at  time.Time
+}
+
+// heldMessage es un mensaje retenido hasta que lo adelanten otros left mensajes
+type heldMessage struct {
+	msg  *models.Message
+	left int
+}
+
+func newFaultInjector(cfg FaultConfig, clk Clock, seed int64) *faultInjector {
+	return &faultInjector{cfg: cfg, clk: clk, seed: seed}
+}
+
+// faultKey identifica un mensaje sin depender del orden de llegada: su seq con payload v2 o,
+// sin él, el contenido de la lectura (dos lecturas idénticas de un device reciben los mismos fallos)
+func faultKey(msg *models.Message) uint64 {
+	if msg.Seq != 0 {
+		return msg.Seq
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%d|%d|%d|%g|%t", msg.UserID, msg.Bpm, msg.Bpm2, msg.Spo2, msg.Temperature, msg.Moving)
+	return h.Sum64()
+}
+
+// run pasa los mensajes de in a out aplicando los fallos; al cerrarse in entrega los retenidos
+func (f *faultInjector) run(ctx context.Context, in <-chan *models.Message, out chan<- *models.Message, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(out)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.timer:
+			f.timer = nil
+			if !f.release(ctx, out, f.clk.Now()) {
+				return
+			}
+		case msg, ok := <-in:
+			if !ok {
+				// sin más entradas no se espera a la latencia: con reloj manual nadie lo avanzaría
+				if !f.release(ctx, out, time.Time{}) {
+					return
+				}
+				for _, h := range f.held {
+					if !f.deliver(ctx, out, h.msg) {
+						return
+					}
+				}
+				return
+			}
+			if !f.inject(ctx, out, msg) {
+				return
+			}
+		}
+	}
+}
+
+// release entrega los retrasados que vencen hasta now (todos si now es cero) y programa el siguiente
+func (f *faultInjector) release(ctx context.Context, out chan<- *models.Message, now time.Time) bool {
+	for len(f.delayed) > 0 && (now.IsZero() || !f.delayed[0].at.After(now)) {
+		msg := f.delayed[0].msg
+		f.delayed = f.delayed[1:]
+		if !f.deliver(ctx, out, msg) {
+			return false
+		}
+	}
+	if len(f.delayed) > 0 && f.timer == nil {
+		f.timer = f.clk.After(f.delayed[0].at.Sub(now))
+	}
+	return true
+}
+
+// inject decide los fallos de un mensaje y lo entrega; false si se canceló la ejecución
+func (f *faultInjector) inject(ctx context.Context, out chan<- *models.Message, msg *models.Message) bool {
+	rng := newKeyedRand(f.seed, faultStreamBase+uint64(msg.DeviceId), faultKey(msg))
+	// todas las decisiones se toman siempre, en el mismo orden, para que cada una dependa sólo
+	// de la clave y no de las que la preceden
+	drop := rng.Float64() < f.cfg.Drop
+	outOfRange := rng.Float64() < f.cfg.OutOfRange
+	null := rng.Float64() < f.cfg.Null
+	truncate := rng.Float64() < f.cfg.Truncate
+	corrupt := rng.Float64() < f.cfg.Corrupt
+	duplicate := rng.Float64() < f.cfg.Duplicate
+	reorder := rng.Float64() < f.cfg.Reorder
+	burst := rng.Float64() < f.cfg.Latency.Probability
+
+	if drop {
+		f.tag(FaultDrop, msg, "")
+		return true
+	}
+	// los demás fallos alteran una copia para no tocar el mensaje del worker; la captura anota
+	// el payload que sale realmente y los fallos aplicados (los descartes sólo quedan en el log)
+	copied := *msg
+	msg = &copied
+	if outOfRange {
+		f.tag(FaultOutOfRange, msg, f.outOfRange(rng, msg))
+	}
+	if null {
+		if detail, ok := f.nullField(rng, msg); ok {
+			f.tag(FaultNull, msg, detail)
+		}
+	}
+	if truncate {
+		if detail, ok := f.truncate(rng, msg); ok {
+			f.tag(FaultTruncate, msg, detail)
+		}
+	}
+	if corrupt {
+		if detail, ok := f.corrupt(rng, msg); ok {
+			f.tag(FaultCorrupt, msg, detail)
+		}
+	}
+	if now := f.clk.Now(); burst && now.After(f.burstEnd) {
+		f.burstEnd = now.Add(time.Duration(f.cfg.Latency.Duration))
+		f.tag(FaultLatency, msg, fmt.Sprintf("ráfaga de %s con +%s por mensaje",
+			time.Duration(f.cfg.Latency.Duration), time.Duration(f.cfg.Latency.Delay)))
+	}
+
+	msgs := []*models.Message{msg}
+	if duplicate {
+		f.tag(FaultDuplicate, msg, "")
+		dup := *msg
+		msgs = append(msgs, &dup)
+	}
+	if reorder {
+		left := 1 + rng.Intn(f.cfg.ReorderWindow)
+		f.tag(FaultReorder, msg, fmt.Sprintf("retenido, lo adelantan %d mensajes", left))
+		f.held = append(f.held, heldMessage{msg: msgs[0], left: left})
+		msgs = msgs[1:]
+	}
+
+	for _, m := range msgs {
+		if !f.send(ctx, out, m) {
+			return false
+		}
+		// cada mensaje entregado adelanta a los retenidos; los que cumplen su cuenta salen detrás
+		var due []*models.Message
+		kept := f.held[:0]
+		for _, h := range f.held {
+			if h.left--; h.left <= 0 {
+				due = append(due, h.msg)
+			} else {
+				kept = append(kept, h)
+			}
+		}
+		f.held = kept
+		for _, d := range due {
+			if !f.send(ctx, out, d) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// send entrega un mensaje o, si hay una ráfaga de latencia en curso, lo encola hasta que
+// venza su retraso sin frenar a los que llegan detrás
+func (f *faultInjector) send(ctx context.Context, out chan<- *models.Message, msg *models.Message) bool {
+	if now := f.clk.Now(); !now.After(f.burstEnd) {
+		// el retraso es fijo, así que la cola queda ordenada por vencimiento
+		f.delayed = append(f.delayed, delayedMessage{msg: msg, at: now.Add(time.Duration(f.cfg.Latency.Delay))})
+		if f.timer == nil {
+			f.timer = f.clk.After(time.Duration(f.cfg.Latency.Delay))
+		}
+		return true
+	}
+	return f.deliver(ctx, out, msg)
+}
+
+// deliver pasa un mensaje al publisher; false si se canceló la ejecución
+func (f *faultInjector) deliver(ctx context.Context, out chan<- *models.Message, msg *models.Message) bool {
+	select {
+	case out <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tag deja el fallo en el log con lo necesario para encontrarlo en el backend
+func (f *faultInjector) tag(kind string, msg *models.Message, detail string) {
+	countFault(kind)
+	if kind != FaultDrop {
+		if msg.Fault != "" {
+			msg.Fault += ","
+		}
+		msg.Fault += kind
+	}
+	id := fmt.Sprintf("device %d", msg.DeviceId)
+	if msg.Seq != 0 {
+		id += fmt.Sprintf(" seq %d", msg.Seq)
+	}
+	if msg.MessageID != "" {
+		id += " message_id " + msg.MessageID
+	}
+	if detail != "" {
+		id += ": " + detail
+	}
+	log.Printf("[fallo:%s] %s", kind, id)
+}
+
+// outOfRange pone un valor que ningún sensor real puede dar
+func (f *faultInjector) outOfRange(rng *keyedRand, msg *models.Message) string {
+	switch rng.Intn(4) {
+	case 0:
+		msg.Bpm = []int{0, 255, 300}[rng.Intn(3)]
+		return fmt.Sprintf("bpm=%d", msg.Bpm)
+	case 1:
+		msg.Bpm2 = []int{0, 255, 300}[rng.Intn(3)]
+		return fmt.Sprintf("bpm2=%d", msg.Bpm2)
+	case 2:
+		msg.Spo2 = []int{0, 101, 127}[rng.Intn(3)]
+		return fmt.Sprintf("spo2=%d", msg.Spo2)
+	}
+	msg.Temperature = []float64{-5, 0, 48.5}[rng.Intn(3)]
+	return fmt.Sprintf("temperature=%g", msg.Temperature)
+}
+
+// nullField serializa el mensaje con un campo a null; la trama esp32 no tiene nulos
+func (f *faultInjector) nullField(rng *keyedRand, msg *models.Message) (string, bool) {
+	format := currentPayload()
+	if msg.Raw != nil || format.encoding == EncodingESP32 {
+		return "", false
+	}
+	tree := format.tree(msg)
+	paths := tree.leaves(nil)
+	path := paths[rng.Intn(len(paths))]
+	tree.set(path, nil)
+	data, err := format.encodeTree(tree)
+	if err != nil {
+		return "", false
+	}
+	msg.Raw = data
+	return "campo " + strings.Join(path, "."), true
+}
+
+// truncate corta el payload serializado en un punto al azar
+func (f *faultInjector) truncate(rng *keyedRand, msg *models.Message) (string, bool) {
+	data, err := currentPayload().encode(msg)
+	if err != nil || len(data) < 2 {
+		return "", false
+	}
+	n := 1 + rng.Intn(len(data)-1)
+	msg.Raw = data[:n:n]
+	return fmt.Sprintf("%d de %d bytes", n, len(data)), true
+}
+
+// caracteres que rompen la sintaxis de un JSON
+const jsonBreakers = `{}[]":,\`
+
+// corrupt sustituye de 1 a 3 bytes del payload serializado
+func (f *faultInjector) corrupt(rng *keyedRand, msg *models.Message) (string, bool) {
+	format := currentPayload()
+	data, err := format.encode(msg)
+	if err != nil || len(data) == 0 {
+		return "", false
+	}
+	data = append([]byte(nil), data...)
+	n := 1 + rng.Intn(3)
+	offsets := make([]string, n)
+	for i := range n {
+		at := rng.Intn(len(data))
+		if format.text() {
+			data[at] = jsonBreakers[rng.Intn(len(jsonBreakers))]
+		} else {
+			data[at] ^= byte(1 + rng.Intn(255))
+		}
+		offsets[i] = fmt.Sprint(at)
+	}
+	msg.Raw = data
+	return "bytes " + strings.Join(offsets, ", "), true
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"simulator/src/models"
+)
+
+func TestLatencyDelaysWithoutBlocking(t *testing.T) {
+	clk := NewManualClock(testStart)
+	cfg := FaultConfig{Latency: LatencyFault{Probability: 1, Duration: Duration(time.Hour), Delay: Duration(time.Minute)}}
+	in := make(chan *models.Message)
+	out := make(chan *models.Message, 10)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go newFaultInjector(cfg, clk, 1).run(context.Background(), in, out, &wg)
+
+	// la etapa sigue aceptando mensajes aunque los anteriores estén retenidos
+	for seq := uint64(1); seq <= 3; seq++ {
+		select {
+		case in <- &models.Message{DeviceId: 1, Seq: seq}:
+		case <-time.After(time.Second):
+			t.Fatalf("el mensaje %d quedó bloqueado por la latencia", seq)
+		}
+	}
+	select {
+	case msg := <-out:
+		t.Fatalf("seq %d entregado antes de su retraso", msg.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(time.Minute)
+	for seq := uint64(1); seq <= 3; seq++ {
+		select {
+		case msg := <-out:
+			if msg.Seq != seq {
+				t.Fatalf("seq %d, se esperaba %d", msg.Seq, seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("seq %d no salió al vencer su retraso", seq)
+		}
+	}
+
+	// al cerrarse la entrada se entregan los pendientes sin esperar al reloj
+	in <- &models.Message{DeviceId: 1, Seq: 4}
+	close(in)
+	wg.Wait()
+	if msg, ok := <-out; !ok || msg.Seq != 4 {
+		t.Fatal("el retrasado pendiente se perdió al cerrar la entrada")
+	}
+}
+
+func TestFaultsDependOnMessageNotArrival(t *testing.T) {
+	cfg := FaultConfig{Drop: 0.5}
+	collect := func(order []uint64) map[uint64]bool {
+		clk := NewManualClock(testStart)
+		in := make(chan *models.Message)
+		out := make(chan *models.Message, len(order))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go newFaultInjector(cfg, clk, 42).run(context.Background(), in, out, &wg)
+		for _, seq := range order {
+			in <- &models.Message{DeviceId: 7, Seq: seq}
+		}
+		close(in)
+		wg.Wait()
+		kept := map[uint64]bool{}
+		for msg := range out {
+			kept[msg.Seq] = true
+		}
+		return kept
+	}
+	a := collect([]uint64{1, 2, 3, 4, 5, 6, 7, 8})
+	b := collect([]uint64{8, 3, 6, 1, 7, 2, 5, 4})
+	for seq := uint64(1); seq <= 8; seq++ {
+		if a[seq] != b[seq] {
+			t.Fatalf("seq %d: el descarte cambió con el orden de llegada", seq)
+		}
+	}
+}
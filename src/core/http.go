@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"simulator/src/models"
+)
+
+// HTTPConfig configura el sink que envía las lecturas por POST a una API de ingesta
+type HTTPConfig struct {
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers"`        // p. ej. Authorization: Bearer ...
+	Timeout       Duration          `json:"timeout"`        // por petición
+	BatchSize     int               `json:"batch_size"`     // 1 = un POST por mensaje; más = array JSON
+	FlushInterval Duration          `json:"flush_interval"` // envía un lote incompleto pasado este tiempo
+}
+
+// respuestas recibidas por código de estado; 0 agrupa los errores de red
+var (
+	httpStatusMu sync.Mutex
+	httpStatus   = make(map[int]uint64)
+)
+
+// validate revisa la sección http
+func (h HTTPConfig) validate() []error {
+	var errs []error
+	if h.URL == "" {
+		errs = append(errs, fmt.Errorf("http.url vacía: indíquela en el archivo o en HTTP_URL"))
+	} else if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		errs = append(errs, fmt.Errorf("http.url %q debe empezar por http:// o https://", h.URL))
+	}
+	if h.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("http.timeout debe ser positivo"))
+	}
+	if h.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("http.batch_size debe ser al menos 1 (es %d)", h.BatchSize))
+	}
+	if h.BatchSize > 1 && h.FlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("http.flush_interval debe ser positivo cuando batch_size > 1"))
+	}
+	return errs
+}
+
+// HTTPSink publica cada mensaje, o lotes de mensajes, con POST. Reintenta por su
+// cuenta las respuestas 5xx y 429 para no volver a encolar mensajes de un lote.
+type HTTPSink struct {
+	cfg    HTTPConfig
+	policy RetryPolicy
+	client *http.Client
+
+	// mu protege el lote y serializa sus envíos: el del temporizador y el de lote lleno
+	mu    sync.Mutex
+	batch []batchedMessage
+	timer *time.Timer
+}
+
+// batchedMessage es un mensaje que espera en el lote con la función que anota su resultado
+type batchedMessage struct {
+	msg    *models.Message
+	settle func(error)
+}
+
+// NewHTTPSink crea el sink; no abre conexiones hasta el primer envío
+func NewHTTPSink(cfg HTTPConfig, policy RetryPolicy) *HTTPSink {
+	return &HTTPSink{
+		cfg:    cfg,
+		policy: policy,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout)},
+	}
+}
+
+// Publish envía el mensaje o lo añade al lote en curso. Los mensajes que esperan en
+// el lote devuelven errBuffered y su resultado se anota con settleFrom al enviarse.
+func (s *HTTPSink) Publish(ctx context.Context, msg *models.Message) error {
+	if s.cfg.BatchSize <= 1 {
+		return s.send(ctx, []*models.Message{msg})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batch)+1 < s.cfg.BatchSize {
+		s.batch = append(s.batch, batchedMessage{msg: msg, settle: settleFrom(ctx)})
+		if s.timer == nil {
+			s.timer = time.AfterFunc(time.Duration(s.cfg.FlushInterval), s.flushPending)
+		}
+		return errBuffered
+	}
+	// el mensaje actual completa el lote: su resultado es el de la llamada
+	return s.sendBatch(ctx, append(s.takeBatch(), batchedMessage{msg: msg}))
+}
+
+// takeBatch debe llamarse con mu tomado
+func (s *HTTPSink) takeBatch() []batchedMessage {
+	batch := s.batch
+	s.batch = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return batch
+}
+
+// sendBatch envía el lote y anota el resultado de los mensajes que esperaban en él; debe
+// llamarse con mu tomado
+func (s *HTTPSink) sendBatch(ctx context.Context, batch []batchedMessage) error {
+	msgs := make([]*models.Message, len(batch))
+	for i, b := range batch {
+		msgs[i] = b.msg
+	}
+	err := s.send(ctx, msgs)
+	for _, b := range batch {
+		if b.settle != nil {
+			b.settle(err)
+		}
+	}
+	return err
+}
+
+// flushPending envía el lote incompleto, ya sea por tiempo o al cerrar
+func (s *HTTPSink) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.takeBatch()
+	if len(batch) == 0 {
+		return
+	}
+
+	// no depende del contexto de la simulación, que puede estar ya cancelado
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	if err := s.sendBatch(ctx, batch); err != nil {
+		log.Printf("Lote HTTP de %d mensajes descartado: %v", len(batch), err)
+	}
+}
+
+// send serializa los mensajes (un objeto, o un array si es un lote) y los envía con reintentos
+func (s *HTTPSink) send(ctx context.Context, msgs []*models.Message) error {
+	var body []byte
+	var err error
+	if s.cfg.BatchSize <= 1 {
+		body, err = encodeMessage(msgs[0])
+	} else if body, err = currentPayload().encodeBatch(msgs); err != nil {
+		err = fmt.Errorf("error al serializar el lote: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	attempts, err := s.policy.retry(ctx, func() error {
+		return s.post(ctx, body)
+	})
+	retriedCount.Add(uint64(attempts - 1))
+	if err != nil {
+		return fmt.Errorf("%w (tras %d intentos)", err, attempts)
+	}
+	if LogMessages {
+		fmt.Printf("POST %s: %d mensajes\n", s.cfg.URL, len(msgs))
+	}
+	return nil
+}
+
+// post hace una petición y clasifica la respuesta para retry
+func (s *HTTPSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", currentPayload().contentType())
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		countHTTPStatus(0)
+		return fmt.Errorf("error al enviar a %s: %w", s.cfg.URL, err)
+	}
+	// vaciar el cuerpo permite reutilizar la conexión
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	countHTTPStatus(resp.StatusCode)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		err := fmt.Errorf("%s respondió %s", s.cfg.URL, resp.Status)
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return retryAfter(err, wait)
+		}
+		return err
+	default:
+		// un 4xx no se arregla reintentando
+		return permanent(fmt.Errorf("%s respondió %s", s.cfg.URL, resp.Status))
+	}
+}
+
+// Close envía el lote pendiente
+func (s *HTTPSink) Close() error {
+	s.flushPending()
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// parseRetryAfter admite segundos o una fecha HTTP
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func countHTTPStatus(code int) {
+	httpStatusMu.Lock()
+	httpStatus[code]++
+	httpStatusMu.Unlock()
+}
+
+// HTTPStatusCounts devuelve cuántas respuestas de cada código recibió el sink http
+func HTTPStatusCounts() map[int]uint64 {
+	httpStatusMu.Lock()
+	defer httpStatusMu.Unlock()
+	counts := make(map[int]uint64, len(httpStatus))
+	for code, n := range httpStatus {
+		counts[code] = n
+	}
+	return counts
+}
+
+func resetHTTPStatus() {
+	httpStatusMu.Lock()
+	httpStatus = make(map[int]uint64)
+	httpStatusMu.Unlock()
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"simulator/src/models"
+)
+
+// ingestServer es una API de ingesta que guarda el tamaño de cada lote recibido
+type ingestServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches []int
+	status  int
+}
+
+func newIngestServer(t *testing.T, status int) *ingestServer {
+	s := &ingestServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []models.Message
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("lote no válido: %v", err)
+		}
+		s.mu.Lock()
+		s.batches = append(s.batches, len(batch))
+		s.mu.Unlock()
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ingestServer) received() (batches []int, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.batches {
+		total += n
+	}
+	return append([]int(nil), s.batches...), total
+}
+
+func batchConfig(url string) HTTPConfig {
+	return HTTPConfig{URL: url, Timeout: Duration(time.Second), BatchSize: 3, FlushInterval: Duration(time.Hour)}
+}
+
+func TestHTTPSinkBatchSettles(t *testing.T) {
+	setPayload(PayloadConfig{Version: PayloadV1, Encoding: EncodingJSON})
+	srv := newIngestServer(t, http.StatusAccepted)
+	sink := NewHTTPSink(batchConfig(srv.URL), RetryPolicy{MaxAttempts: 1, Multiplier: 1})
+
+	var mu sync.Mutex
+	var settled []error
+	ctx := withSettle(context.Background(), func(err error) {
+		mu.Lock()
+		settled = append(settled, err)
+		mu.Unlock()
+	})
+	var direct int
+	for i := 1; i <= 7; i++ {
+		err := sink.Publish(ctx, &models.Message{DeviceId: i})
+		switch {
+		case errors.Is(err, errBuffered):
+		case err == nil:
+			direct++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	batches, total := srv.received()
+	if len(batches) != 3 || total != 7 {
+		t.Fatalf("lotes %v, se esperaban [3 3 1]", batches)
+	}
+	// los que completan un lote se anotan al volver; los que esperaban, con settle
+	if direct != 2 || len(settled) != 5 {
+		t.Fatalf("%d directos y %d diferidos, se esperaban 2 y 5", direct, len(settled))
+	}
+	for _, err := range settled {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHTTPSinkBatchFailureSettlesError(t *testing.T) {
+	setPayload(PayloadConfig{Version: PayloadV1, Encoding: EncodingJSON})
+	srv := newIngestServer(t, http.StatusBadRequest)
+	sink := NewHTTPSink(batchConfig(srv.URL), RetryPolicy{MaxAttempts: 1, Multiplier: 1})
+
+	var failed int
+	ctx := withSettle(context.Background(), func(err error) {
+		if err != nil {
+			failed++
+		}
+	})
+	sink.Publish(ctx, &models.Message{DeviceId: 1})
+	sink.Publish(ctx, &models.Message{DeviceId: 2})
+	if err := sink.Publish(ctx, &models.Message{DeviceId: 3}); err == nil {
+		t.Fatal("un 400 no devolvió error")
+	}
+	if failed != 2 {
+		t.Fatalf("%d diferidos fallidos, se esperaban 2", failed)
+	}
+}
+
+func TestHTTPSinkPipelineCountsOnce(t *testing.T) {
+	srv, other := newIngestServer(t, http.StatusOK), newIngestServer(t, http.StatusOK)
+	cfg := manualConfig()
+	cfg.HTTP = batchConfig(srv.URL)
+	cfg.HTTP.BatchSize = 10
+	otherCfg := cfg.HTTP
+	otherCfg.URL, otherCfg.BatchSize = other.URL, 7
+	setPayload(cfg.Payload)
+	// dos sinks que difieren los mismos mensajes: cada uno debe contarse una sola vez
+	sink := NewMultiSink(NewHTTPSink(cfg.HTTP, cfg.Retry), NewHTTPSink(otherCfg, cfg.Retry))
+
+	if err := StartSimulationWithSink(cfg, sink); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-SimulationDone():
+	case <-time.After(10 * time.Second):
+		StopSimulation()
+		t.Fatal("la simulación no terminó")
+	}
+
+	_, total := srv.received()
+	if _, n := other.received(); n != total {
+		t.Fatalf("las APIs recibieron %d y %d mensajes", total, n)
+	}
+	stats := GetStats()
+	if total != 3*59 || stats.Published != uint64(total) || stats.Failed != 0 {
+		t.Fatalf("la API recibió %d, publicados %d y fallidos %d; se esperaban %d", total, stats.Published, stats.Failed, 3*59)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// errOfflineDropped es el resultado de un mensaje que salió del buffer offline por estar lleno
+var errOfflineDropped = errors.New("descartado del buffer offline lleno")
+
+// offlineMessage es una publicación pendiente mientras el broker no está disponible
+type offlineMessage struct {
+	topic   string
+	payload string
+	opts    PublishOptions
+	settle  func(error) // anota el mensaje al reenviarse; en modo por sensor sólo la última lectura
+}
+
+// offlineQueue es una cola FIFO acotada; al llenarse descarta los mensajes más antiguos
+type offlineQueue struct {
+	mu      sync.Mutex
+	items   []offlineMessage
+	limit   int
+	dropped uint64
+}
+
+func newOfflineQueue(limit int) *offlineQueue {
+	return &offlineQueue{limit: limit}
+}
+
+// push encola un mensaje; devuelve false si la cola está deshabilitada (límite 0)
+func (q *offlineQueue) push(m offlineMessage) bool {
+	return q.enqueue(m, false)
+}
+
+// pushIfPending encola m sólo si ya hay mensajes esperando. Comprobar y encolar bajo el mismo
+// lock evita que replay dé la cola por vacía justo antes de que llegue m.
+func (q *offlineQueue) pushIfPending(m offlineMessage) bool {
+	return q.enqueue(m, true)
+}
+
+// enqueue añade m; si la cola está llena descarta el más antiguo y anota su resultado
+func (q *offlineQueue) enqueue(m offlineMessage, onlyPending bool) bool {
+	q.mu.Lock()
+	if q.limit <= 0 || (onlyPending && len(q.items) == 0) {
+		q.mu.Unlock()
+		return false
+	}
+	var evicted offlineMessage
+	if len(q.items) >= q.limit {
+		evicted = q.items[0]
+		q.items = q.items[1:]
+		q.dropped++
+	}
+	q.items = append(q.items, m)
+	q.mu.Unlock()
+
+	if evicted.settle != nil {
+		evicted.settle(errOfflineDropped)
+	}
+	return true
+}
+
+// peek devuelve el mensaje más antiguo sin quitarlo
+func (q *offlineQueue) peek() (offlineMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return offlineMessage{}, false
+	}
+	return q.items[0], true
+}
+
+// pop quita el mensaje más antiguo
+func (q *offlineQueue) pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) > 0 {
+		q.items = q.items[1:]
+	}
+}
+
+func (q *offlineQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *offlineQueue) droppedCount() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOfflineQueueSettlesEvicted(t *testing.T) {
+	q := newOfflineQueue(1)
+	var settled []error
+	settle := func(err error) { settled = append(settled, err) }
+	q.push(offlineMessage{topic: "a", settle: settle})
+	q.push(offlineMessage{topic: "b", settle: settle})
+	if !q.pushIfPending(offlineMessage{topic: "c", settle: settle}) {
+		t.Fatal("pushIfPending no encoló con mensajes pendientes")
+	}
+
+	if len(settled) != 2 || !errors.Is(settled[0], errOfflineDropped) || !errors.Is(settled[1], errOfflineDropped) {
+		t.Fatalf("resultados %v, se esperaban dos descartes", settled)
+	}
+	if m, _ := q.peek(); m.topic != "c" || q.droppedCount() != 2 {
+		t.Fatalf("queda %q con %d descartes", m.topic, q.droppedCount())
+	}
+}
+
+func TestOfflineQueueDisabled(t *testing.T) {
+	q := newOfflineQueue(0)
+	if q.push(offlineMessage{}) || q.pushIfPending(offlineMessage{}) {
+		t.Fatal("una cola con límite 0 aceptó mensajes")
+	}
+}
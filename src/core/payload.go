@@ -0,0 +1,253 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"simulator/src/models"
+)
+
+// versiones del payload publicado
+const (
+	PayloadV1 = 1 // sólo las lecturas, como lo esperan los consumidores originales
+	PayloadV2 = 2 // añade timestamp, seq y message_id
+)
+
+// formatos del timestamp de la medición
+const (
+	TimestampRFC3339 = "rfc3339"
+	TimestampEpochMs = "epoch_ms"
+)
+
+// formato RFC3339 con milisegundos, para poder medir latencias
+const rfc3339Millis = "2006-01-02T15:04:05.000Z07:00"
+
+// PayloadConfig elige qué campos lleva cada mensaje publicado y cómo se codifica
+type PayloadConfig struct {
+	Version   int                      `json:"version"`   // 1 (por defecto) o 2
+	Timestamp string                   `json:"timestamp"` // v2: rfc3339 (por defecto) o epoch_ms
+	Schema    string                   `json:"schema"`    // nombre en schemas; vacío = los campos de models.Message
+	Encoding  string                   `json:"encoding"`  // json (por defecto), cbor, msgpack o esp32
+	Schemas   map[string]PayloadSchema `json:"schemas"`
+}
+
+func (p PayloadConfig) validate() []error {
+	var errs []error
+	if _, ok := encodingContentTypes[p.encoding()]; !ok {
+		errs = append(errs, fmt.Errorf("payload.encoding %q no válida (use %s, %s, %s o %s)",
+			p.Encoding, EncodingJSON, EncodingCBOR, EncodingMsgPack, EncodingESP32))
+	}
+	if _, ok := p.Schemas[p.Schema]; p.Schema != "" && !ok {
+		errs = append(errs, fmt.Errorf("payload.schema %q no existe en payload.schemas", p.Schema))
+	}
+	if p.Schema != "" && p.encoding() == EncodingESP32 {
+		errs = append(errs, fmt.Errorf("payload.schema no se aplica a la trama fija esp32"))
+	}
+	names := make([]string, 0, len(p.Schemas))
+	for name := range p.Schemas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		errs = append(errs, p.Schemas[name].validate(name, p.Version)...)
+	}
+	if p.Version != PayloadV1 && p.Version != PayloadV2 {
+		errs = append(errs, fmt.Errorf("payload.version %d no soportada (use %d o %d)", p.Version, PayloadV1, PayloadV2))
+	}
+	if p.Timestamp != "" && p.Timestamp != TimestampRFC3339 && p.Timestamp != TimestampEpochMs {
+		errs = append(errs, fmt.Errorf("payload.timestamp %q no válido: use %s o %s", p.Timestamp, TimestampRFC3339, TimestampEpochMs))
+	}
+	return errs
+}
+
+// messageMeta son los metadatos que el device asigna a una lectura; vacíos en v1
+type messageMeta struct {
+	timestamp any
+	seq       uint64
+	id        string
+}
+
+// apply copia los metadatos al mensaje
+func (m messageMeta) apply(msg *models.Message) {
+	msg.Timestamp = m.timestamp
+	msg.Seq = m.seq
+	msg.MessageID = m.id
+}
+
+// stamper numera las lecturas de un device y les asigna un ID; nil con payload v1
+type stamper struct {
+	cfg PayloadConfig
+	seq uint64
+	ids *rand.Rand
+}
+
+// newStamper crea el numerador de un device; los IDs salen de la semilla, así se repiten con ella
+func newStamper(cfg PayloadConfig, seed int64, deviceID int) *stamper {
+	if cfg.Version < PayloadV2 {
+		return nil
+	}
+	return &stamper{cfg: cfg, ids: newStream(seed, idStreamBase+uint64(deviceID))}
+}
+
+// next devuelve los metadatos de la siguiente lectura, medida en at
+func (s *stamper) next(at time.Time) messageMeta {
+	if s == nil {
+		return messageMeta{}
+	}
+	s.seq++
+	// leer de un rand.Rand nunca falla
+	id, _ := uuid.NewRandomFromReader(s.ids)
+	meta := messageMeta{seq: s.seq, id: id.String()}
+	if s.cfg.Timestamp == TimestampEpochMs {
+		meta.timestamp = at.UnixMilli()
+	} else {
+		meta.timestamp = at.UTC().Format(rfc3339Millis)
+	}
+	return meta
+}
+
+func (p PayloadConfig) encoding() string {
+	if p.Encoding == "" {
+		return EncodingJSON
+	}
+	return p.Encoding
+}
+
+// payloadFormat codifica los mensajes con el esquema y la codificación configurados
+type payloadFormat struct {
+	encoding string
+	schema   *PayloadSchema // nil = los campos de models.Message
+}
+
+// formato activo: lo fijan NewSink y ConnectMqtt, como los tópicos
+var activePayload atomic.Pointer[payloadFormat]
+
+func setPayload(p PayloadConfig) {
+	f := &payloadFormat{encoding: p.encoding()}
+	if s, ok := p.Schemas[p.Schema]; ok {
+		f.schema = &s
+	}
+	activePayload.Store(f)
+}
+
+func currentPayload() *payloadFormat {
+	if f := activePayload.Load(); f != nil {
+		return f
+	}
+	return &payloadFormat{encoding: EncodingJSON}
+}
+
+// tree devuelve el mensaje como objeto ordenado
+func (f *payloadFormat) tree(msg *models.Message) object {
+	if f.schema != nil {
+		return f.schema.tree(msg)
+	}
+	return legacyTree(msg)
+}
+
+// encode serializa un mensaje; uno con Raw (un fallo inyectado) se envía tal cual
+func (f *payloadFormat) encode(msg *models.Message) ([]byte, error) {
+	if msg.Raw != nil {
+		return msg.Raw, nil
+	}
+	switch {
+	case f.encoding == EncodingESP32:
+		return appendESP32(make([]byte, 0, esp32FrameSize), msg), nil
+	case f.encoding == EncodingJSON && f.schema == nil:
+		return json.Marshal(msg)
+	}
+	return f.encodeTree(f.tree(msg))
+}
+
+// encodeTree serializa un mensaje ya armado como objeto; no vale para esp32
+func (f *payloadFormat) encodeTree(o object) ([]byte, error) {
+	switch f.encoding {
+	case EncodingCBOR:
+		return appendCBOR(nil, o)
+	case EncodingMsgPack:
+		return appendMsgPack(nil, o)
+	}
+	return json.Marshal(o)
+}
+
+// encodeBatch serializa un lote: un array en JSON, CBOR y MessagePack, y las tramas
+// seguidas en esp32 (al ser de tamaño fijo se separan sin delimitadores). Cada mensaje
+// se codifica por separado para que uno con un fallo inyectado viaje tal cual en el lote.
+func (f *payloadFormat) encodeBatch(msgs []*models.Message) ([]byte, error) {
+	var b []byte
+	switch f.encoding {
+	case EncodingJSON:
+		b = append(b, '[')
+	case EncodingCBOR:
+		b = cborHead(b, 4, uint64(len(msgs)))
+	case EncodingMsgPack:
+		b = msgpackLen(b, len(msgs), 0x90, 0xdc)
+	}
+	for i, m := range msgs {
+		data, err := f.encode(m)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && f.encoding == EncodingJSON {
+			b = append(b, ',')
+		}
+		b = append(b, data...)
+	}
+	if f.encoding == EncodingJSON {
+		b = append(b, ']')
+	}
+	return b, nil
+}
+
+// encodeSensor serializa una sola lectura para los tópicos por sensor, en la unidad del esquema
+func (f *payloadFormat) encodeSensor(sensor string, msg *models.Message) ([]byte, error) {
+	value := sensor
+	if sensor == "motion" {
+		value = "moving"
+	}
+	v := schemaValue(value, f.schema.unit(value), msg)
+	switch f.encoding {
+	case EncodingCBOR:
+		return appendCBOR(nil, v)
+	case EncodingMsgPack:
+		return appendMsgPack(nil, v)
+	case EncodingESP32:
+		return nil, fmt.Errorf("la trama esp32 no admite tópicos por sensor")
+	}
+	// texto plano, que también es JSON válido
+	switch v := v.(type) {
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', -1, 64), nil
+	case bool:
+		return strconv.AppendBool(nil, v), nil
+	}
+	return nil, fmt.Errorf("sensor %q desconocido", sensor)
+}
+
+func (f *payloadFormat) contentType() string {
+	return encodingContentTypes[f.encoding]
+}
+
+// text indica si el payload es legible tal cual (JSON) o binario
+func (f *payloadFormat) text() bool {
+	return f.encoding == EncodingJSON
+}
+
+// printable devuelve el payload tal cual si es texto o en hexadecimal si es binario
+func (f *payloadFormat) printable(data []byte) string {
+	if f.text() {
+		return string(data)
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
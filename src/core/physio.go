@@ -0,0 +1,129 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Vitals es una lectura instantánea de los sensores del guante
+type Vitals struct {
+	HeartRate   float64
+	HeartRate2  float64
+	SpO2        float64
+	Temperature float64
+	Moving      bool
+}
+
+// Parámetros del modelo fisiológico
+const (
+	hrReversion   = 0.10 // velocidad de retorno a la media (1/s)
+	spo2Reversion = 0.05
+	tempReversion = 0.01
+
+	hrNoise   = 0.8 // desviación del paseo aleatorio por sqrt(s)
+	spo2Noise = 0.15
+	tempNoise = 0.01
+
+	hrSensorNoise = 1.5 // ruido entre los dos sensores de pulso
+
+	hrCircadian   = 4.0 // amplitud circadiana
+	tempCircadian = 0.3
+
+	hrEffort   = 30.0 // incremento máximo al estar en movimiento
+	spo2Effort = 0.8
+	tempEffort = 0.2
+
+	effortTau     = 30.0  // segundos para alcanzar el esfuerzo máximo
+	meanMoveTime  = 45.0  // duración media de un episodio de movimiento (s)
+	meanStillTime = 240.0 // duración media de reposo (s)
+	maxStep       = 60.0  // paso máximo integrado entre dos lecturas (s)
+)
+
+// vitalModel mantiene el estado fisiológico de un dispositivo entre lecturas
+type vitalModel struct {
+	baseHR   float64
+	baseSpO2 float64
+	baseTemp float64
+
+	hr     float64
+	spo2   float64
+	temp   float64
+	effort float64
+	moving bool
+	last   time.Time
+	rng    *rand.Rand
+}
+
+// newVitalModel crea un modelo con una línea base propia del paciente dentro del perfil
+func newVitalModel(profile SensorProfile, rng *rand.Rand) *vitalModel {
+	m := &vitalModel{
+		baseHR:   profile.HeartRate.sample(rng),
+		baseSpO2: profile.SpO2.sample(rng),
+		baseTemp: profile.Temperature.sample(rng),
+		rng:      rng,
+	}
+	m.hr = m.baseHR
+	m.spo2 = m.baseSpO2
+	m.temp = m.baseTemp
+	return m
+}
+
+// Next implementa SensorSource: el modelo sintético nunca se agota
+func (m *vitalModel) Next(now time.Time) (Vitals, bool) {
+	return m.step(now), true
+}
+
+// step avanza el modelo hasta now y devuelve la lectura resultante
+func (m *vitalModel) step(now time.Time) Vitals {
+	dt := 1.0
+	if !m.last.IsZero() {
+		dt = math.Min(math.Max(now.Sub(m.last).Seconds(), 0), maxStep)
+	}
+	m.last = now
+
+	// episodios de movimiento como cadena de Markov de dos estados
+	if m.moving {
+		m.moving = m.rng.Float64() >= dt/meanMoveTime
+	} else {
+		m.moving = m.rng.Float64() < dt/meanStillTime
+	}
+
+	// el esfuerzo sube al moverse y decae en reposo
+	target := 0.0
+	if m.moving {
+		target = 1.0
+	}
+	m.effort += (target - m.effort) * (1 - math.Exp(-dt/effortTau))
+
+	// deriva circadiana con máximo hacia las 16h
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	circ := math.Cos(2 * math.Pi * (hour - 16) / 24)
+
+	m.hr = meanRevert(m.rng, m.hr, m.baseHR+hrCircadian*circ+hrEffort*m.effort, hrReversion, hrNoise, dt)
+	m.spo2 = meanRevert(m.rng, m.spo2, m.baseSpO2-spo2Effort*m.effort, spo2Reversion, spo2Noise, dt)
+	m.temp = meanRevert(m.rng, m.temp, m.baseTemp+tempCircadian*circ+tempEffort*m.effort, tempReversion, tempNoise, dt)
+
+	m.hr = clamp(m.hr, 40, 200)
+	m.spo2 = clamp(m.spo2, 70, 100)
+	m.temp = clamp(m.temp, 34, 42)
+
+	return Vitals{
+		HeartRate:   m.hr,
+		HeartRate2:  clamp(m.hr+m.rng.NormFloat64()*hrSensorNoise, 40, 200),
+		SpO2:        m.spo2,
+		Temperature: m.temp,
+		Moving:      m.moving,
+	}
+}
+
+// meanRevert integra de forma exacta un paso de Ornstein-Uhlenbeck hacia target
+func meanRevert(rng *rand.Rand, x, target, theta, sigma, dt float64) float64 {
+	decay := math.Exp(-theta * dt)
+	spread := sigma * math.Sqrt((1-decay*decay)/(2*theta))
+	return target + (x-target)*decay + spread*rng.NormFloat64()
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Min(math.Max(v, lo), hi)
+}
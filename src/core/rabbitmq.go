@@ -1,49 +1,224 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"sync/atomic"
+	"time"
 	"encoding/json"
 	"simulator/src/models"
 
-	"github.com/joho/godotenv"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-var client mqtt.Client
+var (
+	// conexión general abierta por ConnectMqtt
+	shared    *mqttConn
+	brokerCfg BrokerConfig
+	topics    TopicsConfig
 
-// Conexión general al broker MQTT
-func ConnectMqtt() {
-	err := godotenv.Load()
+	// LogMessages imprime cada mensaje publicado; el modo headless lo desactiva
+	LogMessages = true
+
+	// canal público que notifica a la GUI los cambios de estado de la conexión
+	ConnEvents chan ConnState
+	connState  atomic.Int32
+)
+
+// mqttConn es un cliente paho con su buffer offline: los mensajes producidos
+// mientras no hay conexión se reenvían al reconectar
+type mqttConn struct {
+	client    mqtt.Client
+	offline   *offlineQueue
+	replaying atomic.Bool
+
+	// sólo en los clientes de device
+	deviceID int
+	status   string // tópico de presencia; vacío si no hay Last Will
+	dialing  atomic.Bool
+	dialCtx  context.Context // contexto y limitador de dial, para reconectar tras una caída
+	limiter  *rateLimiter
+	setupErr error // configuración TLS inválida para este device
+}
+
+// tiempo máximo de espera de las operaciones contra el broker
+const brokerTimeout = 10 * time.Second
+
+// errBuffered indica que el mensaje quedó en el buffer offline en lugar de publicarse
+var errBuffered = errors.New("mensaje guardado en el buffer offline")
+
+// ConnState es el estado de la conexión con el broker
+type ConnState int32
+
+const (
+	ConnDisconnected ConnState = iota
+	ConnConnecting
+	ConnConnected
+	ConnReconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnecting:
+		return "conectando"
+	case ConnConnected:
+		return "conectado"
+	case ConnReconnecting:
+		return "reconectando"
+	default:
+		return "desconectado"
+	}
+}
+
+// Conexión general al broker MQTT, con reconexión automática y buffer offline
+func ConnectMqtt(cfg *Config) error {
+	topics = cfg.Topics
+	brokerCfg = cfg.Broker
+	setPayload(cfg.Payload)
+	subscriptionCfg = cfg
+	if ConnEvents == nil {
+		ConnEvents = make(chan ConnState, 16)
+	}
+
+	conn := &mqttConn{offline: newOfflineQueue(cfg.Broker.OfflineBuffer)}
+	opts, err := newClientOptions(cfg.Broker, cfg.Broker.ClientID, nil)
 	if err != nil {
-		fmt.Println("No se pudieron cargar las variables de entorno")
+		return err
+	}
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		setConnState(ConnConnected)
+		go conn.replay()
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		fmt.Println("Conexión con el broker perdida:", err)
+		setConnState(ConnDisconnected)
+	})
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		setConnState(ConnReconnecting)
+	})
+
+	setConnState(ConnConnecting)
+	conn.client = mqtt.NewClient(opts)
+	shared = conn
+
+	// con ConnectRetry el token sólo termina al conectar; si tarda seguimos en segundo plano
+	token := conn.client.Connect()
+	if !token.WaitTimeout(brokerTimeout) {
+		fmt.Printf("Broker %s no disponible, reintentando en segundo plano\n", cfg.Broker.Host)
+		return nil
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("no se pudo conectar al broker %s: %w", cfg.Broker.Host, err)
 	}
 
-	USER := os.Getenv("USER_RABBIT")
-	PASSWORD := os.Getenv("PASSWORD_RABBIT")
-	HOST_RABBIT := os.Getenv("HOST_RABBIT")
-	CLIENT_ID := os.Getenv("CLIENT_ID")
+	fmt.Println("Conectado correctamente al broker MQTT")
+	return nil
+}
 
+// newClientOptions arma las opciones comunes a la conexión general y a las de cada device;
+// msg indica el device para los certificados de cliente por device
+func newClientOptions(cfg BrokerConfig, clientID string, msg *models.Message) (*mqtt.ClientOptions, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker("tcp://" + HOST_RABBIT)
-	opts.SetClientID(CLIENT_ID)
-	opts.SetUsername(USER)
-	opts.SetPassword(PASSWORD)
+	opts.AddBroker(brokerAddress(cfg))
+	opts.SetClientID(clientID)
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(time.Duration(cfg.ConnectRetryInterval))
+	opts.SetMaxReconnectInterval(time.Duration(cfg.MaxReconnectInterval))
+	if isWebSocket(cfg.Host) && len(cfg.WebSocket.Headers) > 0 {
+		opts.SetHTTPHeaders(cfg.WebSocket.httpHeaders())
+	}
+	if brokerSchemes[brokerScheme(cfg.Host)] {
+		tlsCfg, err := loadTLS(cfg.TLS, msg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsCfg)
+	}
+	return opts, nil
+}
 
-	client = mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+// setConnState guarda el estado y lo notifica a la GUI sin bloquear
+func setConnState(s ConnState) {
+	connState.Store(int32(s))
+	if ConnEvents != nil {
+		select {
+		case ConnEvents <- s:
+		default:
+		}
 	}
+}
 
-	fmt.Println("Conectado correctamente al broker MQTT")
+// GetConnEvents expone el canal de estados de conexión a la UI
+func GetConnEvents() <-chan ConnState {
+	return ConnEvents
+}
+
+// ConnectionState devuelve el último estado conocido de la conexión
+func ConnectionState() ConnState {
+	return ConnState(connState.Load())
+}
+
+// replay reenvía en orden los mensajes guardados mientras no había conexión. Si se interrumpe
+// con la conexión abierta lo relanza la siguiente publicación (resumeReplay).
+func (c *mqttConn) replay() {
+	if !c.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.replaying.Store(false)
+
+	sent := 0
+	for {
+		m, ok := c.offline.peek()
+		if !ok {
+			break
+		}
+		if err := waitToken(c.client.Publish(m.topic, m.opts.QoS, m.opts.Retain, m.payload)); err != nil {
+			fmt.Println("Reenvío del buffer offline interrumpido:", err)
+			return
+		}
+		c.offline.pop()
+		if m.settle != nil {
+			m.settle(nil)
+		}
+		sent++
+	}
+	if sent > 0 {
+		fmt.Printf("Reenviados %d mensajes del buffer offline\n", sent)
+	}
+}
+
+// resumeReplay lanza replay si no hay uno en curso
+func (c *mqttConn) resumeReplay() {
+	if !c.replaying.Load() {
+		go c.replay()
+	}
+}
+
+// offlineTotals suma los mensajes en cola y los descartados de todas las conexiones MQTT
+func offlineTotals() (queued int, dropped uint64) {
+	conns := deviceConns()
+	if shared != nil {
+		conns = append(conns, shared)
+	}
+	for _, c := range conns {
+		queued += c.offline.len()
+		dropped += c.offline.droppedCount()
+	}
+	return queued, dropped
 }
 
 // Suscripción al tópico device.data
-func SubscribeToDeviceData() {
-	TOPIC := os.Getenv("TOPICCON")
+func SubscribeToDeviceData() error {
+	TOPIC := topics.Subscribe
 
-	if token := client.Subscribe(TOPIC, 1, func(client mqtt.Client, msg mqtt.Message) {
+	if shared == nil {
+		return fmt.Errorf("MQTT no inicializado")
+	}
+	token := shared.client.Subscribe(TOPIC, topics.SubscribeQoS, func(client mqtt.Client, msg mqtt.Message) {
 		fmt.Printf("Mensaje recibido en %s: %s\n", msg.Topic(), string(msg.Payload()))
 
 		var device models.DeviceData
@@ -52,28 +227,183 @@ func SubscribeToDeviceData() {
 			return
 		}
 
+		// las respuestas salen siempre por la conexión general
 		simulated := GenerateSensorData(device)
-		dataJSON, err := json.Marshal(simulated)
-		if err != nil {
-			fmt.Println("Error al convertir datos simulados a JSON:", err)
+		if simulated == nil {
 			return
 		}
+		if err := (&mqttSink{}).Publish(context.Background(), simulated); err != nil && !errors.Is(err, errBuffered) {
+			fmt.Println("Error al publicar datos simulados:", err)
+		}
+	})
+	if err := waitToken(token); err != nil {
+		return fmt.Errorf("no se pudo suscribir a %s: %w", TOPIC, err)
+	}
+
+	fmt.Println("Suscrito al tópico:", TOPIC)
+	return nil
+}
+
+// Publicar mensajes al tópico esp32.datos; sin conexión se guardan en el buffer offline
+func PublishData(message string) error {
+	if shared == nil {
+		return fmt.Errorf("MQTT no inicializado")
+	}
+	return shared.publish(topics.Publish, topics.publishOptions(""), message)
+}
+
+// publish publica en un tópico concreto, pasando por el buffer offline si hace falta
+func (c *mqttConn) publish(topic string, opts PublishOptions, message string) error {
+	return c.publishSettled(topic, opts, message, countOutcome)
+}
+
+// publishSettled es publish con la función que anota el mensaje si se reenvía más tarde
+func (c *mqttConn) publishSettled(topic string, opts PublishOptions, message string, settle func(error)) error {
+	return c.send(offlineMessage{topic: topic, payload: message, opts: opts, settle: settle})
+}
+
+// send publica pending o lo deja en el buffer offline
+func (c *mqttConn) send(pending offlineMessage) error {
+	topic, opts, message := pending.topic, pending.opts, pending.payload
+	if !c.client.IsConnectionOpen() {
+		if c.offline.push(pending) {
+			return errBuffered
+		}
+		return fmt.Errorf("broker desconectado y buffer offline deshabilitado")
+	}
+	// mientras quede algo en cola se encola también lo nuevo para no desordenar; con la
+	// conexión abierta la cola sólo avanza si hay un replay en marcha
+	if c.offline.pushIfPending(pending) {
+		c.resumeReplay()
+		return errBuffered
+	}
+	if err := waitToken(c.client.Publish(topic, opts.QoS, opts.Retain, message)); err != nil {
+		if !c.client.IsConnectionOpen() && c.offline.push(pending) {
+			return errBuffered
+		}
+		return fmt.Errorf("error al publicar en %s: %w", topic, err)
+	}
+	if LogMessages {
+		fmt.Println("Mensaje publicado en", topic, ":", currentPayload().printable([]byte(message)))
+	}
+	return nil
+}
 
-		PublishData(string(dataJSON))
-	}); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
-	} else {
-		fmt.Println("Suscrito al tópico:", TOPIC)
+// waitToken espera un token de paho con límite de tiempo
+func waitToken(token mqtt.Token) error {
+	if !token.WaitTimeout(brokerTimeout) {
+		return fmt.Errorf("tiempo de espera agotado (%s)", brokerTimeout)
 	}
+	return token.Error()
 }
 
-// Publicar mensajes al tópico esp32.datos
-func PublishData(message string) {
-	TOPIC := os.Getenv("TOPICPUB")
+// Cierra la conexión con el broker
+func DisconnectMqtt() {
+	disconnectDevices()
+	// también detiene los reintentos de conexión en segundo plano
+	if shared != nil {
+		shared.client.Disconnect(250)
+	}
+}
+
+// mqttSink publica en el tópico configurado (ya resuelto para cada device), por la
+// conexión general o por un cliente propio de cada device
+type mqttSink struct {
+	perDevice bool
+	dialCtx   context.Context
+	stopDial  context.CancelFunc
+	limiter   *rateLimiter
+}
+
+// NewMQTTSink crea un sink sobre la conexión abierta por ConnectMqtt. Con
+// broker.per_device_clients abre además, al ritmo de broker.connect_rate, un cliente por device de la flota.
+func NewMQTTSink(cfg *Config) Sink {
+	s := &mqttSink{perDevice: cfg.Broker.PerDeviceClients}
+	if s.perDevice {
+		resetConnFailures()
+		s.dialCtx, s.stopDial = context.WithCancel(context.Background())
+		s.limiter = newRateLimiter(cfg.Broker.ConnectRate)
+		go dialFleet(s.dialCtx, cfg.Fleet, s.limiter)
+	}
+	return s
+}
+
+func (s *mqttSink) Publish(ctx context.Context, msg *models.Message) error {
+	conn := shared
+	if s.perDevice {
+		conn = deviceConn(msg.DeviceId, msg.UserID)
+		conn.dial(s.dialCtx, s.limiter)
+	}
+	if topics.PerSensor {
+		return publishPerSensor(conn, msg, settleFrom(ctx))
+	}
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	return conn.publishSettled(renderTemplate(topics.Publish, msg), topics.publishOptions(""), string(data), settleFrom(ctx))
+}
+
+// publishPerSensor publica cada lectura en su propio tópico. Si sólo fallan algunas el error
+// es permanente: reintentar el mensaje volvería a publicar las lecturas que ya salieron.
+func publishPerSensor(conn *mqttConn, msg *models.Message, settle func(error)) error {
+	var errs []error
+	buffered := false
+	for i, sensor := range sensorNames {
+		data, err := currentPayload().encodeSensor(sensor, msg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		part := offlineMessage{
+			topic:   sensorTopic(topics.Publish, sensor, msg),
+			payload: string(data),
+			opts:    topics.publishOptions(sensor),
+		}
+		// con lecturas ya fallidas el mensaje cuenta como fallido, no al reenviarse
+		if i == len(sensorNames)-1 && len(errs) == 0 {
+			part.settle = settle
+		}
+		if buffered {
+			// tras la primera lectura en cola van todas a la cola: así la última siempre
+			// está en ella y el reenvío cuenta el mensaje una sola vez
+			if !conn.offline.push(part) {
+				// la lectura se pierde: el mensaje cuenta como fallido ahora y no al reenviarse,
+				// y no se reintenta para no repetir las que ya están en cola
+				return permanent(fmt.Errorf("lectura %s descartada: buffer offline deshabilitado", sensor))
+			}
+			if conn.client.IsConnectionOpen() {
+				conn.resumeReplay()
+			}
+			continue
+		}
+		err = conn.send(part)
+		if errors.Is(err, errBuffered) {
+			buffered = true
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch {
+	case len(errs) == len(sensorNames):
+		return errors.Join(errs...)
+	case len(errs) > 0:
+		return permanent(fmt.Errorf("publicadas %d de %d lecturas: %w",
+			len(sensorNames)-len(errs), len(sensorNames), errors.Join(errs...)))
+	}
+	if buffered {
+		return errBuffered
+	}
+	return nil
+}
 
-	if token := client.Publish(TOPIC, 0, false, message); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
-	} else {
-		fmt.Println("Mensaje publicado en", TOPIC, ":", message)
+// Close no cierra la conexión general: su ciclo de vida lo controla ConnectMqtt/DisconnectMqtt.
+// Los clientes de cada device sí se cierran, publicando antes su estado offline.
+func (s *mqttSink) Close() error {
+	if s.perDevice {
+		s.stopDial()
+		s.limiter.stop()
+		disconnectDevices()
 	}
+	return nil
 }
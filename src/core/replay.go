@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"simulator/src/models"
+)
+
+// ReplayConfig reproduce una captura en lugar de simular la flota
+type ReplayConfig struct {
+	File         string      `json:"file"`  // captura NDJSON o CSV; vacío = simulación normal
+	Speed        float64     `json:"speed"` // 1 = tiempos originales, 10 = diez veces más rápido, 0 = sin esperas
+	Loop         bool        `json:"loop"`  // volver a empezar al terminar
+	SkipFailed   bool        `json:"skip_failed"`
+	DeviceOffset int         `json:"device_offset"` // se suma a cada device_id que no esté en device_map
+	UserOffset   int         `json:"user_offset"`
+	DeviceMap    map[int]int `json:"device_map"` // device_id original -> nuevo
+	UserMap      map[int]int `json:"user_map"`
+}
+
+// validate revisa la sección replay
+func (r ReplayConfig) validate() []error {
+	if r.Speed < 0 {
+		return []error{fmt.Errorf("replay.speed no puede ser negativa (es %g)", r.Speed)}
+	}
+	return nil
+}
+
+// remap devuelve una copia del mensaje con los IDs traducidos
+func (r ReplayConfig) remap(msg *models.Message) *models.Message {
+	out := *msg
+	if id, ok := r.DeviceMap[msg.DeviceId]; ok {
+		out.DeviceId = id
+	} else {
+		out.DeviceId += r.DeviceOffset
+	}
+	if id, ok := r.UserMap[msg.UserID]; ok {
+		out.UserID = id
+	} else {
+		out.UserID += r.UserOffset
+	}
+	return &out
+}
+
+// LoadCapture lee una captura escrita por el sink de captura, en NDJSON o CSV
+func LoadCapture(path string) ([]CaptureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo abrir la captura: %w", err)
+	}
+	defer f.Close()
+
+	var records []CaptureRecord
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		records, err = readCaptureCSV(f)
+	} else {
+		records, err = readCaptureNDJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("captura %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("captura %s vacía", path)
+	}
+	return records, nil
+}
+
+func readCaptureNDJSON(r io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec CaptureRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("línea %d: %w", line, err)
+		}
+		if rec.Message == nil {
+			return nil, fmt.Errorf("línea %d: falta message", line)
+		}
+		if ms, ok := rec.Message.Timestamp.(float64); ok {
+			// JSON decodifica el epoch en ms como float64
+			rec.Message.Timestamp = int64(ms)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+func readCaptureCSV(r io.Reader) ([]CaptureRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("sin cabecera: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range captureColumns {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("falta la columna %s", name)
+		}
+	}
+
+	var records []CaptureRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec, err := parseCaptureRow(row, col)
+		if err != nil {
+			return nil, fmt.Errorf("línea %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func parseCaptureRow(row []string, col map[string]int) (CaptureRecord, error) {
+	get := func(name string) string { return row[col[name]] }
+	var errs []error
+	atoi := func(name string) int {
+		n, err := strconv.Atoi(get(name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		return n
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, get("time"))
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("time: %w", err)
+	}
+	temp, err := strconv.ParseFloat(get("temperature"), 64)
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("temperature: %w", err)
+	}
+	moving, err := strconv.ParseBool(get("moving"))
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("moving: %w", err)
+	}
+	msg := &models.Message{
+		DeviceId:    atoi("device_id"),
+		UserID:      atoi("user_id"),
+		Bpm:         atoi("bpm"),
+		Bpm2:        atoi("bpm2"),
+		Spo2:        atoi("spo2"),
+		Temperature: temp,
+		Moving:      moving,
+	}
+	if i, ok := col["timestamp"]; ok && row[i] != "" {
+		// epoch en ms o RFC3339, tal como se publicó
+		if ms, err := strconv.ParseInt(row[i], 10, 64); err == nil {
+			msg.Timestamp = ms
+		} else {
+			msg.Timestamp = row[i]
+		}
+	}
+	if i, ok := col["seq"]; ok && row[i] != "" {
+		seq, err := strconv.ParseUint(row[i], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("seq: %w", err))
+		}
+		msg.Seq = seq
+	}
+	if i, ok := col["message_id"]; ok {
+		msg.MessageID = row[i]
+	}
+	if len(errs) > 0 {
+		return CaptureRecord{}, errs[0]
+	}
+	rec := CaptureRecord{Time: t, Topic: get("topic"), Outcome: get("outcome"), Error: get("error"), Message: msg}
+	if i, ok := col["fault"]; ok {
+		rec.Fault = row[i]
+	}
+	if i, ok := col["payload"]; ok {
+		rec.Payload = row[i]
+	}
+	if i, ok := col["payload_hex"]; ok {
+		rec.PayloadHex = row[i]
+	}
+	return rec, nil
+}
+
+// replayDevices cuenta los devices distintos que aparecerán en la reproducción
+func replayDevices(records []CaptureRecord, r ReplayConfig) int {
+	seen := make(map[int]bool)
+	for _, rec := range records {
+		seen[r.remap(rec.Message).DeviceId] = true
+	}
+	return len(seen)
+}
+
+// replayCapture publica los registros con sus tiempos originales divididos por la
+// velocidad. Sin loop termina al llegar al final, lo que cierra la simulación; con loop
+// cada vuelta renumera los mensajes de payload v2 (relabel).
+func replayCapture(ctx context.Context, records []CaptureRecord, r ReplayConfig, results chan<- *models.Message, prodWG *sync.WaitGroup) {
+	defer prodWG.Done()
+	span := seqSpans(records)
+	for pass := uint64(0); ; pass++ {
+		start := time.Now()
+		first := records[0].Time
+		for _, rec := range records {
+			if r.SkipFailed && rec.Outcome == outcomeFailed {
+				continue
+			}
+			if r.Speed > 0 {
+				due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / r.Speed))
+				if wait := time.Until(due); wait > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(wait):
+					}
+				}
+			}
+			msg := r.remap(rec.Message)
+			if pass > 0 {
+				relabel(msg, rec.Message.DeviceId, pass, span)
+			}
+			select {
+			case results <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !r.Loop {
+			return
+		}
+	}
+}
+
+// seqSpans devuelve el mayor seq de cada device de la captura
+func seqSpans(records []CaptureRecord) map[int]uint64 {
+	span := make(map[int]uint64)
+	for _, rec := range records {
+		span[rec.Message.DeviceId] = max(span[rec.Message.DeviceId], rec.Message.Seq)
+	}
+	return span
+}
+
+// relabel renumera un mensaje de la vuelta pass de un replay en bucle para que los
+// consumidores no la tomen por duplicados: seq continúa tras el mayor del device en la
+// captura (se conservan huecos y desorden) y message_id es un UUID derivado del original
+// y de la vuelta (los duplicados de la captura siguen siéndolo dentro de cada vuelta)
+func relabel(msg *models.Message, deviceID int, pass uint64, span map[int]uint64) {
+	if msg.Seq != 0 {
+		msg.Seq += pass * span[deviceID]
+	}
+	if msg.MessageID != "" {
+		original, err := uuid.Parse(msg.MessageID)
+		if err != nil {
+			original = uuid.NewSHA1(uuid.NameSpaceOID, []byte(msg.MessageID))
+		}
+		msg.MessageID = uuid.NewSHA1(original, strconv.AppendUint(nil, pass, 10)).String()
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"simulator/src/models"
+)
+
+func TestReplayLoopRelabelsPasses(t *testing.T) {
+	records := []CaptureRecord{
+		{Time: testStart, Message: &models.Message{DeviceId: 1, Seq: 1, MessageID: "1a8cee90-c3b3-40b5-b257-3075dada82aa"}},
+		{Time: testStart, Message: &models.Message{DeviceId: 1, Seq: 3, MessageID: "dacb4cbd-361a-4a1e-926c-5ffac019b1ea"}},
+		{Time: testStart, Message: &models.Message{DeviceId: 2}}, // payload v1: sin metadatos
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan *models.Message)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go replayCapture(ctx, records, ReplayConfig{Loop: true}, results, &wg)
+
+	var got []*models.Message
+	for len(got) < 3*len(records) {
+		select {
+		case msg := <-results:
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			t.Fatal("la reproducción no avanzó")
+		}
+	}
+	cancel()
+
+	ids := make(map[string]bool)
+	for pass := 0; pass < 3; pass++ {
+		first, second, v1 := got[3*pass], got[3*pass+1], got[3*pass+2]
+		if want := uint64(1 + 3*pass); first.Seq != want || second.Seq != want+2 {
+			t.Fatalf("vuelta %d: seq %d y %d, se esperaban %d y %d", pass, first.Seq, second.Seq, want, want+2)
+		}
+		for _, id := range []string{first.MessageID, second.MessageID} {
+			if ids[id] {
+				t.Fatalf("vuelta %d repite message_id %s", pass, id)
+			}
+			ids[id] = true
+		}
+		if v1.Seq != 0 || v1.MessageID != "" {
+			t.Fatalf("vuelta %d añadió metadatos a un mensaje v1: %+v", pass, v1)
+		}
+	}
+	if records[0].Message.Seq != 1 {
+		t.Fatal("relabel modificó la captura")
+	}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy define cuántas veces y con qué espera se reintenta una publicación
+type RetryPolicy struct {
+	MaxAttempts    int      `json:"max_attempts"` // intentos totales, incluido el primero
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	Multiplier     float64  `json:"multiplier"`
+}
+
+// defaultRetryPolicy reintenta dos veces con espera exponencial corta
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: Duration(200 * time.Millisecond),
+		MaxBackoff:     Duration(5 * time.Second),
+		Multiplier:     2,
+	}
+}
+
+// validate devuelve los problemas de la política con el prefijo del campo
+func (p RetryPolicy) validate(field string) []error {
+	var errs []error
+	if p.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts debe ser al menos 1 (es %d)", field, p.MaxAttempts))
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		errs = append(errs, fmt.Errorf("%s: los backoff no pueden ser negativos", field))
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		errs = append(errs, fmt.Errorf("%s.max_backoff (%s) menor que initial_backoff (%s)",
+			field, time.Duration(p.MaxBackoff), time.Duration(p.InitialBackoff)))
+	}
+	if p.Multiplier < 1 {
+		errs = append(errs, fmt.Errorf("%s.multiplier debe ser al menos 1 (es %g)", field, p.Multiplier))
+	}
+	return errs
+}
+
+// permanentError marca un fallo que no se arregla reintentando (p. ej. HTTP 400)
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// retryAfterError pide esperar al menos after antes del siguiente intento
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string { return e.err.Error() }
+func (e retryAfterError) Unwrap() error { return e.err }
+
+func retryAfter(err error, after time.Duration) error {
+	return retryAfterError{err, after}
+}
+
+// retry ejecuta fn hasta que tenga éxito, se agoten los intentos, el error sea
+// permanente o se cancele ctx. Devuelve el número de intentos realizados y el último error.
+func (p RetryPolicy) retry(ctx context.Context, fn func() error) (int, error) {
+	backoff := time.Duration(p.InitialBackoff)
+	attempts := 0
+	for {
+		attempts++
+		err := fn()
+		if err == nil || attempts >= p.MaxAttempts || errors.As(err, new(permanentError)) {
+			return attempts, err
+		}
+
+		wait := backoff
+		var hint retryAfterError
+		if errors.As(err, &hint) && hint.after > wait {
+			wait = hint.after
+		}
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-time.After(wait):
+		}
+
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if backoff > time.Duration(p.MaxBackoff) {
+			backoff = time.Duration(p.MaxBackoff)
+		}
+	}
+}
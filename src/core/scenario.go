@@ -0,0 +1,169 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// EventKind identifica un evento clínico programable
+type EventKind string
+
+const (
+	EventTachycardia EventKind = "tachycardia"
+	EventBradycardia EventKind = "bradycardia"
+	EventHypoxia     EventKind = "hypoxia"
+	EventFever       EventKind = "fever"
+	EventFall        EventKind = "fall"
+)
+
+// valores objetivo por defecto de cada evento
+var defaultEventTargets = map[EventKind]float64{
+	EventTachycardia: 150,
+	EventBradycardia: 42,
+	EventHypoxia:     84,
+	EventFever:       39.2,
+	EventFall:        25, // incremento de pulso tras la caída
+}
+
+// duración del impacto de una caída (movimiento brusco antes de quedar inmóvil)
+const fallImpact = 3 * time.Second
+
+// ScenarioEvent programa un evento clínico sobre un conjunto de dispositivos
+type ScenarioEvent struct {
+	Kind     EventKind `json:"kind"`
+	Devices  []int     `json:"devices"`  // vacío = todos los dispositivos
+	At       Duration  `json:"at"`       // desde el inicio de la simulación
+	Duration Duration  `json:"duration"` // 0 = hasta el final de la simulación
+	Ramp     Duration  `json:"ramp"`     // transición gradual de entrada y salida
+	Target   float64   `json:"target"`   // bpm, % o °C según el evento; 0 = valor por defecto
+}
+
+// Scenario es un guion de eventos que se superpone a los datos generados
+type Scenario struct {
+	Name   string          `json:"name"`
+	Events []ScenarioEvent `json:"events"`
+}
+
+// LoadScenario lee y valida un escenario en JSON
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer el escenario: %w", err)
+	}
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("escenario %s mal formado: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("escenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Validate comprueba que los eventos sean aplicables
+func (s *Scenario) Validate() error {
+	for i, ev := range s.Events {
+		if _, ok := defaultEventTargets[ev.Kind]; !ok {
+			return fmt.Errorf("evento %d: tipo desconocido %q", i, ev.Kind)
+		}
+		if ev.At < 0 || ev.Duration < 0 || ev.Ramp < 0 {
+			return fmt.Errorf("evento %d (%s): los tiempos no pueden ser negativos", i, ev.Kind)
+		}
+		if ev.Duration > 0 && 2*ev.Ramp > ev.Duration {
+			return fmt.Errorf("evento %d (%s): la rampa (%s) no cabe dos veces en la duración (%s)",
+				i, ev.Kind, time.Duration(ev.Ramp), time.Duration(ev.Duration))
+		}
+		for _, id := range ev.Devices {
+			if id <= 0 {
+				return fmt.Errorf("evento %d (%s): device_id inválido %d", i, ev.Kind, id)
+			}
+		}
+	}
+	return nil
+}
+
+// apply superpone los eventos activos del device en el instante elapsed
+func (s *Scenario) apply(rng *rand.Rand, deviceID int, elapsed time.Duration, v Vitals) Vitals {
+	if s == nil {
+		return v
+	}
+	for _, ev := range s.Events {
+		if !ev.targets(deviceID) {
+			continue
+		}
+		w := ev.intensity(elapsed)
+		if w <= 0 {
+			continue
+		}
+		target := ev.Target
+		if target == 0 {
+			target = defaultEventTargets[ev.Kind]
+		}
+		v = ev.layer(rng, v, target, w, elapsed-time.Duration(ev.At))
+	}
+	return v
+}
+
+func (ev ScenarioEvent) targets(deviceID int) bool {
+	if len(ev.Devices) == 0 {
+		return true
+	}
+	for _, id := range ev.Devices {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// intensity devuelve el peso 0..1 del evento teniendo en cuenta las rampas
+func (ev ScenarioEvent) intensity(elapsed time.Duration) float64 {
+	t := elapsed - time.Duration(ev.At)
+	if t < 0 {
+		return 0
+	}
+	ramp := time.Duration(ev.Ramp)
+	w := 1.0
+	if ramp > 0 && t < ramp {
+		w = float64(t) / float64(ramp)
+	}
+	if ev.Duration > 0 {
+		left := time.Duration(ev.Duration) - t
+		if left <= 0 {
+			return 0
+		}
+		if ramp > 0 && left < ramp {
+			w = math.Min(w, float64(left)/float64(ramp))
+		}
+	}
+	return w
+}
+
+// layer modifica la lectura según el tipo de evento
+func (ev ScenarioEvent) layer(rng *rand.Rand, v Vitals, target, w float64, since time.Duration) Vitals {
+	switch ev.Kind {
+	case EventTachycardia, EventBradycardia:
+		delta := v.HeartRate2 - v.HeartRate
+		v.HeartRate = blend(rng, v.HeartRate, target, w, 2)
+		v.HeartRate2 = v.HeartRate + delta
+	case EventHypoxia:
+		v.SpO2 = blend(rng, v.SpO2, target, w, 0.5)
+	case EventFever:
+		v.Temperature = blend(rng, v.Temperature, target, w, 0.05)
+	case EventFall:
+		// impacto brusco y después inmóvil con el pulso alterado
+		v.Moving = since < fallImpact
+		v.HeartRate += target * w
+		v.HeartRate2 += target * w
+	}
+	return v
+}
+
+// blend acerca value al objetivo con peso w manteniendo algo de variabilidad
+func blend(rng *rand.Rand, value, target, w, noise float64) float64 {
+	return value + w*(target-value) + w*noise*rng.NormFloat64()
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"simulator/src/models"
+)
+
+// valores de la lectura que un esquema puede publicar
+var schemaValues = []string{"device_id", "user_id", "bpm", "bpm2", "spo2", "temperature", "moving",
+	"timestamp", "seq", "message_id"}
+
+// valores que sólo existen con payload versión 2
+var schemaMetaValues = []string{"timestamp", "seq", "message_id"}
+
+// unidades admitidas por valor; la primera es la interna y la que se usa por defecto
+var schemaUnits = map[string][]string{
+	"temperature": {"celsius", "fahrenheit", "kelvin"},
+	"spo2":        {"percent", "fraction"},
+	"moving":      {"bool", "int"},
+}
+
+// PayloadSchema describe los campos de un mensaje para una versión concreta del backend
+type PayloadSchema struct {
+	Fields []SchemaField `json:"fields"`
+}
+
+// SchemaField publica un valor de la lectura con otro nombre y, si se indica, otra unidad
+type SchemaField struct {
+	Name  string `json:"name"`  // los puntos anidan objetos: "vitals.hr"
+	Value string `json:"value"` // device_id, user_id, bpm, bpm2, spo2, temperature, moving, timestamp, seq o message_id
+	Unit  string `json:"unit"`  // temperature: celsius, fahrenheit, kelvin; spo2: percent, fraction; moving: bool, int
+}
+
+// validate revisa el esquema; version es la del payload, que decide si hay metadatos
+func (s PayloadSchema) validate(name string, version int) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("payload.schemas.%s: "+format, append([]any{name}, args...)...))
+	}
+	if len(s.Fields) == 0 {
+		fail("sin campos")
+	}
+	var paths []string
+	for i, f := range s.Fields {
+		if f.Name == "" || slices.Contains(strings.Split(f.Name, "."), "") {
+			fail("fields[%d].name %q no válido", i, f.Name)
+		}
+		if !slices.Contains(schemaValues, f.Value) {
+			fail("fields[%d].value %q desconocido (use %s)", i, f.Value, strings.Join(schemaValues, ", "))
+		}
+		if slices.Contains(schemaMetaValues, f.Value) && version < PayloadV2 {
+			fail("fields[%d].value %s requiere payload.version %d", i, f.Value, PayloadV2)
+		}
+		if f.Unit != "" && !slices.Contains(schemaUnits[f.Value], f.Unit) {
+			fail("fields[%d].unit %q no válida para %s", i, f.Unit, f.Value)
+		}
+		paths = append(paths, f.Name)
+	}
+	// un campo no puede repetirse ni ser a la vez valor y objeto ("a" y "a.b")
+	slices.Sort(paths)
+	for i := 1; i < len(paths); i++ {
+		if paths[i] == paths[i-1] || strings.HasPrefix(paths[i], paths[i-1]+".") {
+			fail("los campos %q y %q chocan", paths[i-1], paths[i])
+		}
+	}
+	return errs
+}
+
+// unit devuelve la unidad con la que el esquema publica value, o la interna si no lo incluye
+func (s *PayloadSchema) unit(value string) string {
+	if s != nil {
+		for _, f := range s.Fields {
+			if f.Value == value && f.Unit != "" {
+				return f.Unit
+			}
+		}
+	}
+	if units := schemaUnits[value]; len(units) > 0 {
+		return units[0]
+	}
+	return ""
+}
+
+// tree arma el mensaje según el esquema, con los campos en el orden en que se declararon
+func (s *PayloadSchema) tree(msg *models.Message) object {
+	var root object
+	for _, f := range s.Fields {
+		root.set(strings.Split(f.Name, "."), schemaValue(f.Value, s.unit(f.Value), msg))
+	}
+	return root
+}
+
+// schemaValue extrae un valor de la lectura convertido a la unidad pedida
+func schemaValue(value, unit string, msg *models.Message) any {
+	switch value {
+	case "device_id":
+		return int64(msg.DeviceId)
+	case "user_id":
+		return int64(msg.UserID)
+	case "bpm":
+		return int64(msg.Bpm)
+	case "bpm2":
+		return int64(msg.Bpm2)
+	case "spo2":
+		if unit == "fraction" {
+			return float64(msg.Spo2) / 100
+		}
+		return int64(msg.Spo2)
+	case "temperature":
+		switch unit {
+		case "fahrenheit":
+			return round2(msg.Temperature*9/5 + 32)
+		case "kelvin":
+			return round2(msg.Temperature + 273.15)
+		}
+		return msg.Temperature
+	case "moving":
+		if unit == "int" {
+			if msg.Moving {
+				return int64(1)
+			}
+			return int64(0)
+		}
+		return msg.Moving
+	case "timestamp":
+		return msg.Timestamp
+	case "seq":
+		return msg.Seq
+	case "message_id":
+		return msg.MessageID
+	}
+	return nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// legacyTree es el mensaje con los campos de models.Message, para las codificaciones no JSON
+func legacyTree(msg *models.Message) object {
+	o := object{
+		{"device_id", int64(msg.DeviceId)},
+		{"user_id", int64(msg.UserID)},
+		{"bpm", int64(msg.Bpm)},
+		{"spo2", int64(msg.Spo2)},
+		{"bpm2", int64(msg.Bpm2)},
+		{"moving", msg.Moving},
+		{"temperature", msg.Temperature},
+	}
+	if msg.Timestamp != nil {
+		o = append(o, objectField{"timestamp", msg.Timestamp})
+	}
+	if msg.Seq != 0 {
+		o = append(o, objectField{"seq", msg.Seq})
+	}
+	if msg.MessageID != "" {
+		o = append(o, objectField{"message_id", msg.MessageID})
+	}
+	return o
+}
+
+// object es un mapa que conserva el orden de sus campos al codificarse
+type object []objectField
+
+type objectField struct {
+	key   string
+	value any
+}
+
+// set guarda v en la ruta indicada creando los objetos intermedios
+func (o *object) set(path []string, v any) {
+	for i := range *o {
+		if (*o)[i].key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			(*o)[i].value = v
+			return
+		}
+		child, _ := (*o)[i].value.(object)
+		child.set(path[1:], v)
+		(*o)[i].value = child
+		return
+	}
+	if len(path) == 1 {
+		*o = append(*o, objectField{path[0], v})
+		return
+	}
+	var child object
+	child.set(path[1:], v)
+	*o = append(*o, objectField{path[0], child})
+}
+
+// leaves devuelve la ruta de cada valor, recorriendo los objetos anidados
+func (o object) leaves(prefix []string) [][]string {
+	var paths [][]string
+	for _, f := range o {
+		path := append(slices.Clone(prefix), f.key)
+		if child, ok := f.value.(object); ok {
+			paths = append(paths, child.leaves(path)...)
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		val, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
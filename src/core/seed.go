@@ -0,0 +1,76 @@
+package core
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// semilla de la ejecución en curso o de la última; 0 = todavía no se eligió ninguna
+var runSeed atomic.Int64
+
+// flujos aleatorios que no son las lecturas de un device (esas usan su device_id)
+const (
+	workerStreamBase = 1 << 40 // + índice del worker
+	idStreamBase     = 1 << 41 // + device_id, para los message_id
+	faultStreamBase  = 1 << 42 // + device_id, para los fallos inyectados
+	sensorFaultBase  = 1 << 43 // + device_id, para las averías de sensor
+)
+
+// RunSeed devuelve la semilla de la ejecución en curso o de la última
+func RunSeed() int64 {
+	return runSeed.Load()
+}
+
+// chooseSeed devuelve la semilla configurada o, si es 0, una nueva a partir del reloj
+func chooseSeed(seed int64) int64 {
+	for seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return seed
+}
+
+// currentSeed devuelve la semilla de la ejecución; la suscripción elige una si aún no hay
+func currentSeed(configured int64) int64 {
+	runSeed.CompareAndSwap(0, chooseSeed(configured))
+	return runSeed.Load()
+}
+
+// streamSeed deriva de la semilla de la ejecución una semilla independiente por flujo (splitmix64)
+func streamSeed(seed int64, stream uint64) int64 {
+	z := uint64(seed) + (stream+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// keyedRand es un generador barato de crear cuyas salidas dependen sólo de su semilla, su flujo
+// y su clave, no del orden en que se usa respecto a otros; no es seguro para uso concurrente
+type keyedRand struct {
+	seed int64
+	n    uint64
+}
+
+func newKeyedRand(seed int64, stream, key uint64) *keyedRand {
+	return &keyedRand{seed: streamSeed(streamSeed(seed, stream), key)}
+}
+
+func (r *keyedRand) Uint64() uint64 {
+	r.n++
+	return uint64(streamSeed(r.seed, r.n))
+}
+
+// Float64 devuelve un valor en [0, 1)
+func (r *keyedRand) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+// Intn devuelve un valor en [0, n)
+func (r *keyedRand) Intn(n int) int {
+	return int(r.Uint64() % uint64(n))
+}
+
+// newStream crea el generador propio de un flujo; no es seguro para uso concurrente
+func newStream(seed int64, stream uint64) *rand.Rand {
+	return rand.New(rand.NewSource(streamSeed(seed, stream)))
+}
@@ -1,41 +1,77 @@
 package core
 
 import (
+	"fmt"
 	"math"
-	"math/rand"
+	"sync"
+
 	"simulator/src/models"
 )
 
-// Genera datos simulados de sensores en base a un DeviceData recibido
-func GenerateSensorData(device models.DeviceData) *models.Message {
+// dispositivos que llegan por suscripción, cada uno con su fuente de lecturas y su numeración
+var (
+	subscribedDevices   = make(map[int]*subscribedDevice)
+	subscribedDevicesMu sync.Mutex
+	subscriptionCfg     *Config // flota con la que se eligen las fuentes; nil = modelo por defecto
 
-	return &models.Message{
-		DeviceId:    device.IdDevice,
-		UserID:      device.IdUser,
-		Bpm:         int(simulateMeasurement("HeartRate")),
-		Spo2:        int(simulateMeasurement("Oxygen")),
-		Bpm2:        int(simulateMeasurement("HeartRate")),
-		Moving:      rand.Intn(2) == 1, // true o false aleatorio
-		Temperature: float64(simulateMeasurement("Temperature")),
-	}
+	// configuración por defecto para los devices sin subscriptionCfg, armada una sola vez
+	fallbackCfg = sync.OnceValue(DefaultConfig)
+)
+
+type subscribedDevice struct {
+	source SensorSource
+	stamp  *stamper
 }
 
-// Simula una medición según el tipo de sensor
-func simulateMeasurement(sensorType string) float64 {
-	var value float64
-
-	switch sensorType {
-	case "Temperature":
-		value = 36.0 + rand.Float64()*1.0 // 36–38 °C
-	case "Oxygen":
-		value = 90.0 + rand.Float64()*3.0 // 90–100 %
-	case "HeartRate":
-		value = 80.0 + rand.Float64()*10.0 // 60–100 bpm
-	default:
-		value = rand.Float64() * 100.0 // Valor genérico si no se conoce el tipo
+// Genera datos simulados de sensores en base a un DeviceData recibido con la fuente del grupo
+// del device; nil cuando esa fuente se agotó (un dataset sin loop).
+func GenerateSensorData(device models.DeviceData) *models.Message {
+	subscribedDevicesMu.Lock()
+	defer subscribedDevicesMu.Unlock()
+	d, ok := subscribedDevices[device.IdDevice]
+	if !ok {
+		d = newSubscribedDevice(device.IdDevice)
+		subscribedDevices[device.IdDevice] = d
 	}
+	now := SimClock().Now()
+	vitals, ok := d.source.Next(now)
+	if !ok {
+		return nil
+	}
+	msg := buildMessage(device, vitals)
+	d.stamp.next(now).apply(msg)
+	return msg
+}
 
-	// Redondear a dos decimales
-	return math.Round(value*100) / 100
+// newSubscribedDevice usa la fuente del grupo del device o, si no está en la flota, el modelo por defecto
+func newSubscribedDevice(deviceID int) *subscribedDevice {
+	cfg := subscriptionCfg
+	if cfg == nil {
+		cfg = fallbackCfg()
+	}
+	seed := currentSeed(cfg.Seed)
+	d := &subscribedDevice{stamp: newStamper(cfg.Payload, seed, deviceID)}
+	if g, ok := cfg.groupOf(deviceID); ok && subscriptionCfg != nil {
+		source, err := cfg.newSource(g, deviceID, seed, SimClock().Now())
+		if err == nil {
+			d.source = source
+			return d
+		}
+		fmt.Printf("Device %d sin fuente propia, se usa el modelo sintético: %v\n", deviceID, err)
+	}
+	d.source = NewRandomWalkSource(defaultSensorProfile(), newStream(seed, uint64(deviceID)))
+	return d
 }
 
+// buildMessage convierte una lectura en el mensaje que se publica
+func buildMessage(device models.DeviceData, v Vitals) *models.Message {
+	return &models.Message{
+		DeviceId:    device.IdDevice,
+		UserID:      device.IdUser,
+		Bpm:         int(math.Round(v.HeartRate)),
+		Spo2:        int(math.Round(v.SpO2)),
+		Bpm2:        int(math.Round(v.HeartRate2)),
+		Moving:      v.Moving,
+		Temperature: math.Round(v.Temperature*100) / 100, // dos decimales
+	}
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// averías de hardware que se pueden simular en los sensores de un device
+const (
+	SensorLeadOff    = "lead_off"   // sensor desconectado (dedo fuera): lee 0
+	SensorStuck      = "stuck"      // repite el último valor leído antes de la avería
+	SensorDrift      = "drift"      // deriva lineal de rate unidades por minuto
+	SensorSaturation = "saturation" // recorta los valores a los límites min/max del sensor
+	SensorDivergence = "divergence" // uno de los dos pulsos se separa del otro
+)
+
+// sensor al que afecta cada avería si no se indica otro
+var sensorFaultDefaults = map[string]string{
+	SensorLeadOff:    "spo2",
+	SensorStuck:      "hr",
+	SensorDrift:      "temperature",
+	SensorSaturation: "hr",
+	SensorDivergence: "hr2",
+}
+
+// sensores de una lectura, con los nombres de las columnas de los datasets
+var faultSensors = []string{"hr", "hr2", "spo2", "temperature"}
+
+// límites por defecto de la saturación: los de un sensor barato de gama baja
+var saturationLimits = map[string]Range{
+	"hr":          {40, 140},
+	"hr2":         {40, 140},
+	"spo2":        {80, 100},
+	"temperature": {35, 38},
+}
+
+// separación por defecto del pulso averiado en divergence, en bpm por minuto
+const defaultDivergenceRate = 5
+
+// SensorFault programa una avería de sensor sobre un conjunto de dispositivos, a una hora
+// fija (at) o con una probabilidad por lectura a partir de at
+type SensorFault struct {
+	Mode        string   `json:"mode"`        // lead_off, stuck, drift, saturation o divergence
+	Sensor      string   `json:"sensor"`      // hr, hr2, spo2 o temperature; vacío = el habitual del modo
+	Devices     []int    `json:"devices"`     // vacío = todos los dispositivos
+	At          Duration `json:"at"`          // desde el arranque del device
+	Duration    Duration `json:"duration"`    // 0 = hasta el final de la simulación
+	Probability float64  `json:"probability"` // 0 = empieza en at; si no, probabilidad por lectura de que empiece
+	Rate        float64  `json:"rate"`        // drift y divergence: unidades por minuto
+	Min         float64  `json:"min"`         // saturation; 0 y 0 = límites por defecto del sensor
+	Max         float64  `json:"max"`
+}
+
+// sensor devuelve el sensor afectado
+func (f SensorFault) sensor() string {
+	if f.Sensor != "" {
+		return f.Sensor
+	}
+	return sensorFaultDefaults[f.Mode]
+}
+
+// limits devuelve el rango de la saturación
+func (f SensorFault) limits() Range {
+	if f.Min == 0 && f.Max == 0 {
+		return saturationLimits[f.sensor()]
+	}
+	return Range{f.Min, f.Max}
+}
+
+func (f SensorFault) validate(i int) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("sensor_faults[%d]: "+format, append([]any{i}, args...)...))
+	}
+	if _, ok := sensorFaultDefaults[f.Mode]; !ok {
+		fail("mode %q desconocido (use %s, %s, %s, %s o %s)", f.Mode,
+			SensorLeadOff, SensorStuck, SensorDrift, SensorSaturation, SensorDivergence)
+		return errs
+	}
+	switch sensor := f.sensor(); {
+	case !slices.Contains(faultSensors, sensor):
+		fail("sensor %q desconocido (use hr, hr2, spo2 o temperature)", f.Sensor)
+	case f.Mode == SensorDivergence && sensor != "hr" && sensor != "hr2":
+		fail("divergence sólo se aplica a hr o hr2 (es %s)", sensor)
+	}
+	if f.At < 0 || f.Duration < 0 {
+		fail("los tiempos no pueden ser negativos")
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		fail("probability (%g) debe estar entre 0 y 1", f.Probability)
+	}
+	if f.Mode == SensorDrift && f.Rate == 0 {
+		fail("drift requiere rate distinto de 0")
+	}
+	if r := f.limits(); f.Mode == SensorSaturation && r.Min >= r.Max {
+		fail("min (%g) debe ser menor que max (%g)", r.Min, r.Max)
+	}
+	for _, id := range f.Devices {
+		if id <= 0 {
+			fail("device_id inválido %d", id)
+		}
+	}
+	return errs
+}
+
+func (f SensorFault) targets(deviceID int) bool {
+	return len(f.Devices) == 0 || slices.Contains(f.Devices, deviceID)
+}
+
+// activeFault es el estado de una avería en un device concreto
+type activeFault struct {
+	SensorFault
+	active bool
+	done   bool          // una avería a hora fija sólo ocurre una vez
+	since  time.Duration // inicio de la activación en curso, desde el arranque
+	until  time.Duration // fin de la activación en curso; <0 = sin fin
+	stuck  float64       // valor congelado por stuck
+	sign   float64       // sentido de la separación en divergence
+}
+
+// faultySource superpone las averías de sensor a las lecturas de otra fuente
+type faultySource struct {
+	inner    SensorSource
+	faults   []*activeFault
+	deviceID int
+	start    time.Time
+	rng      *rand.Rand
+}
+
+// WithSensorFaults decora src con las averías que afectan al device; como en WithScenario,
+// los tiempos cuentan desde start. rng debe ser un flujo propio para no alterar las lecturas.
+func WithSensorFaults(src SensorSource, faults []SensorFault, deviceID int, start time.Time, rng *rand.Rand) SensorSource {
+	var mine []*activeFault
+	for _, f := range faults {
+		if f.targets(deviceID) {
+			mine = append(mine, &activeFault{SensorFault: f})
+		}
+	}
+	if len(mine) == 0 {
+		return src
+	}
+	return &faultySource{inner: src, faults: mine, deviceID: deviceID, start: start, rng: rng}
+}
+
+func (s *faultySource) Next(now time.Time) (Vitals, bool) {
+	v, ok := s.inner.Next(now)
+	if !ok {
+		return v, false
+	}
+	elapsed := now.Sub(s.start)
+	for _, f := range s.faults {
+		s.schedule(f, elapsed, v)
+		if f.active {
+			s.apply(f, elapsed, &v)
+		}
+	}
+	return v, true
+}
+
+// schedule termina la activación vencida y decide si empieza una nueva
+func (s *faultySource) schedule(f *activeFault, elapsed time.Duration, v Vitals) {
+	if f.active && f.until >= 0 && elapsed >= f.until {
+		f.active = false
+		log.Printf("[averia:%s] device %d sensor %s: fin a los %s", f.Mode, s.deviceID, f.sensor(), elapsed)
+	}
+	if f.active || f.done || elapsed < time.Duration(f.At) {
+		return
+	}
+	f.since = elapsed
+	if f.Probability == 0 {
+		// a hora fija: la ventana es [at, at+duration] aunque la lectura llegue algo después
+		f.since = time.Duration(f.At)
+		f.done = true
+	} else if s.rng.Float64() >= f.Probability {
+		return
+	}
+	f.until = -1
+	if f.Duration > 0 {
+		f.until = f.since + time.Duration(f.Duration)
+		if elapsed >= f.until {
+			return
+		}
+	}
+	f.active = true
+	f.stuck = *v.sensor(f.sensor())
+	f.sign = 1
+	if s.rng.Intn(2) == 0 {
+		f.sign = -1
+	}
+	log.Printf("[averia:%s] device %d sensor %s: inicio a los %s", f.Mode, s.deviceID, f.sensor(), elapsed)
+}
+
+// apply altera la lectura del sensor averiado
+func (s *faultySource) apply(f *activeFault, elapsed time.Duration, v *Vitals) {
+	p := v.sensor(f.sensor())
+	minutes := (elapsed - f.since).Minutes()
+	switch f.Mode {
+	case SensorLeadOff:
+		*p = 0
+	case SensorStuck:
+		*p = f.stuck
+	case SensorDrift:
+		*p += f.Rate * minutes
+	case SensorSaturation:
+		r := f.limits()
+		*p = math.Min(math.Max(*p, r.Min), r.Max)
+	case SensorDivergence:
+		rate := f.Rate
+		if rate == 0 {
+			rate = defaultDivergenceRate
+		}
+		*p = math.Max(0, *p+f.sign*rate*minutes+s.rng.NormFloat64()*2)
+	}
+}
+
+// sensor devuelve el campo de la lectura que mide un sensor
+func (v *Vitals) sensor(name string) *float64 {
+	switch name {
+	case "hr2":
+		return &v.HeartRate2
+	case "spo2":
+		return &v.SpO2
+	case "temperature":
+		return &v.Temperature
+	}
+	return &v.HeartRate
+}
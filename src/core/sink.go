@@ -0,0 +1,246 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"simulator/src/models"
+)
+
+// Sink es un destino de los mensajes simulados (broker, API, archivo...)
+type Sink interface {
+	Publish(ctx context.Context, msg *models.Message) error
+	Close() error
+}
+
+// settleKey guarda en el contexto de Publish cómo anotar el resultado de un mensaje que
+// el sink entrega más tarde (devolvió errBuffered)
+type settleKey struct{}
+
+// withSettle asocia al contexto la función que anota el resultado diferido
+func withSettle(ctx context.Context, settle func(error)) context.Context {
+	return context.WithValue(ctx, settleKey{}, settle)
+}
+
+// settleFrom devuelve la función con la que un sink anota un resultado diferido; por
+// defecto es la del pipeline, que lo cuenta en las estadísticas
+func settleFrom(ctx context.Context) func(error) {
+	if settle, ok := ctx.Value(settleKey{}).(func(error)); ok {
+		return settle
+	}
+	return countOutcome
+}
+
+// sinks disponibles en la configuración
+const (
+	SinkMQTT   = "mqtt"
+	SinkAMQP   = "amqp"
+	SinkStdout = "stdout"
+	SinkHTTP   = "http"
+	SinkFile   = "file" // sólo la captura de capture.path, sin publicar en ningún sitio
+)
+
+// encodeMessage serializa un mensaje tal como lo reciben los consumidores, con el payload configurado
+func encodeMessage(msg *models.Message) ([]byte, error) {
+	data, err := currentPayload().encode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar mensaje simulado: %w", err)
+	}
+	return data, nil
+}
+
+// NewSink construye el sink descrito por cfg.Sinks, con la política de reintentos aplicada a cada uno
+func NewSink(cfg *Config) (Sink, error) {
+	setPayload(cfg.Payload)
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		var s Sink
+		switch name {
+		case SinkMQTT:
+			// sin conexión la simulación sigue y los mensajes esperan en el buffer offline
+			if shared == nil {
+				closeAll(sinks)
+				return nil, fmt.Errorf("MQTT no inicializado - conecta primero")
+			}
+			s = NewMQTTSink(cfg)
+		case SinkAMQP:
+			amqpSink, err := NewAMQPSink(cfg.AMQP)
+			if err != nil {
+				closeAll(sinks)
+				return nil, err
+			}
+			s = amqpSink
+		case SinkStdout:
+			s = NewWriterSink(os.Stdout)
+		case SinkFile:
+			s = discardSink{}
+		case SinkHTTP:
+			// reintenta cada petición por su cuenta, sin pasar por WithRetry
+			sinks = append(sinks, NewHTTPSink(cfg.HTTP, cfg.Retry))
+			continue
+		default:
+			closeAll(sinks)
+			return nil, fmt.Errorf("sink desconocido %q", name)
+		}
+		sinks = append(sinks, WithRetry(s, cfg.Retry))
+	}
+	sink := sinks[0]
+	if len(sinks) > 1 {
+		sink = NewMultiSink(sinks...)
+	}
+	if cfg.Capture.Path != "" {
+		captured, err := WithCapture(sink, cfg.Capture, cfg.captureTopic())
+		if err != nil {
+			sink.Close()
+			return nil, err
+		}
+		sink = captured
+	}
+	return sink, nil
+}
+
+func closeAll(sinks []Sink) {
+	for _, s := range sinks {
+		s.Close()
+	}
+}
+
+// retrySink reintenta las publicaciones fallidas de otro sink
+type retrySink struct {
+	inner  Sink
+	policy RetryPolicy
+}
+
+// WithRetry envuelve un sink con una política de reintentos
+func WithRetry(s Sink, policy RetryPolicy) Sink {
+	return &retrySink{inner: s, policy: policy}
+}
+
+func (r *retrySink) Publish(ctx context.Context, msg *models.Message) error {
+	buffered := false
+	attempts, err := r.policy.retry(ctx, func() error {
+		err := r.inner.Publish(ctx, msg)
+		if errors.Is(err, errBuffered) {
+			// guardado para reenviar más tarde: no tiene sentido reintentar
+			buffered = true
+			return nil
+		}
+		return err
+	})
+	retriedCount.Add(uint64(attempts - 1))
+	if err != nil {
+		return fmt.Errorf("%w (tras %d intentos)", err, attempts)
+	}
+	if buffered {
+		return errBuffered
+	}
+	return nil
+}
+
+func (r *retrySink) Close() error {
+	return r.inner.Close()
+}
+
+// multiSink reparte cada mensaje entre varios sinks
+type multiSink struct {
+	sinks []Sink
+}
+
+// NewMultiSink publica cada mensaje en todos los sinks indicados
+func NewMultiSink(sinks ...Sink) Sink {
+	return &multiSink{sinks: sinks}
+}
+
+// Publish entrega el mensaje a todos los sinks. Si alguno lo deja para más tarde, el
+// resultado se anota una sola vez, cuando terminan todos, con los errores de cualquiera.
+func (m *multiSink) Publish(ctx context.Context, msg *models.Message) error {
+	// pending empieza en 1 para que no se anote antes de repartirlo a todos los sinks
+	j := &joinedSettle{settle: settleFrom(ctx), pending: 1}
+	var errs []error
+	buffered := false
+	for _, s := range m.sinks {
+		j.add()
+		err := s.Publish(withSettle(ctx, j.done), msg)
+		if errors.Is(err, errBuffered) {
+			buffered = true
+			continue
+		}
+		j.done(nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buffered {
+		j.done(errors.Join(errs...))
+		return errBuffered
+	}
+	return errors.Join(errs...)
+}
+
+// joinedSettle junta los resultados diferidos de varios sinks en uno
+type joinedSettle struct {
+	mu      sync.Mutex
+	pending int
+	errs    []error
+	settle  func(error)
+}
+
+func (j *joinedSettle) add() {
+	j.mu.Lock()
+	j.pending++
+	j.mu.Unlock()
+}
+
+func (j *joinedSettle) done(err error) {
+	j.mu.Lock()
+	if err != nil {
+		j.errs = append(j.errs, err)
+	}
+	j.pending--
+	last := j.pending == 0
+	j.mu.Unlock()
+	if last {
+		j.settle(errors.Join(j.errs...))
+	}
+}
+
+func (m *multiSink) Close() error {
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writerSink escribe cada mensaje en una línea: el JSON, o el payload binario en hexadecimal
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink escribe los mensajes en w, por ejemplo os.Stdout
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Publish(_ context.Context, msg *models.Message) error {
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	line := currentPayload().printable(data) + "\n"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = io.WriteString(s.w, line)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// SensorSource produce las lecturas sucesivas de un device. Cada device tiene la suya,
+// así que no necesita ser segura para uso concurrente.
+type SensorSource interface {
+	// Next devuelve la lectura del instante now; ok=false cuando la fuente ya no tiene más
+	Next(now time.Time) (v Vitals, ok bool)
+}
+
+// fuentes de lecturas disponibles para un grupo de la flota
+const (
+	SourceRandomWalk = "random_walk" // modelo fisiológico continuo (por defecto)
+	SourceUniform    = "uniform"     // valores independientes dentro de los rangos del profile
+	SourceDataset    = "dataset"     // grabación real de datasets
+)
+
+var sensorSources = []string{SourceRandomWalk, SourceUniform, SourceDataset}
+
+// uniformSource sortea cada lectura de forma independiente dentro del profile
+type uniformSource struct {
+	profile SensorProfile
+	rng     *rand.Rand
+}
+
+// NewUniformSource crea una fuente de ruido uniforme sin memoria entre lecturas
+func NewUniformSource(profile SensorProfile, rng *rand.Rand) SensorSource {
+	return uniformSource{profile: profile, rng: rng}
+}
+
+func (s uniformSource) Next(time.Time) (Vitals, bool) {
+	return Vitals{
+		HeartRate:   s.profile.HeartRate.sample(s.rng),
+		HeartRate2:  s.profile.HeartRate.sample(s.rng),
+		SpO2:        s.profile.SpO2.sample(s.rng),
+		Temperature: s.profile.Temperature.sample(s.rng),
+		Moving:      s.rng.Intn(2) == 0,
+	}, true
+}
+
+// NewRandomWalkSource crea una fuente con el modelo fisiológico del profile
+func NewRandomWalkSource(profile SensorProfile, rng *rand.Rand) SensorSource {
+	return newVitalModel(profile, rng)
+}
+
+// NewDatasetSource recorre una grabación desde offset; sin loop se agota al terminarla
+func NewDatasetSource(ds *Dataset, loop bool, offset time.Duration) SensorSource {
+	return newDatasetCursor(ds, loop, offset)
+}
+
+// scenarioSource superpone los eventos de un escenario a las lecturas de otra fuente
+type scenarioSource struct {
+	inner    SensorSource
+	scenario *Scenario
+	deviceID int
+	start    time.Time
+	rng      *rand.Rand
+}
+
+// WithScenario decora src con los eventos del escenario que afectan al device;
+// los tiempos del escenario cuentan desde start, el arranque del device en tiempo simulado
+func WithScenario(src SensorSource, scenario *Scenario, deviceID int, start time.Time, rng *rand.Rand) SensorSource {
+	if scenario == nil {
+		return src
+	}
+	return &scenarioSource{inner: src, scenario: scenario, deviceID: deviceID, start: start, rng: rng}
+}
+
+func (s *scenarioSource) Next(now time.Time) (Vitals, bool) {
+	v, ok := s.inner.Next(now)
+	if !ok {
+		return v, false
+	}
+	return s.scenario.apply(s.rng, s.deviceID, now.Sub(s.start), v), true
+}
+
+// groupSourceName devuelve la fuente del grupo: la indicada, dataset si tiene uno o random_walk
+func groupSourceName(g DeviceGroup) string {
+	switch {
+	case g.Source != "":
+		return g.Source
+	case g.Dataset != "":
+		return SourceDataset
+	default:
+		return SourceRandomWalk
+	}
+}
+
+// newSource crea la fuente de lecturas de un device según su grupo, con el escenario y las averías
+// de sensor superpuestos; toda su aleatoriedad sale de flujos del device, así la misma semilla
+// repite sus lecturas
+func (c *Config) newSource(g DeviceGroup, deviceID int, seed int64, start time.Time) (SensorSource, error) {
+	profile := c.Profiles[c.groupProfileName(g)]
+	rng := newStream(seed, uint64(deviceID))
+	var src SensorSource
+	switch name := groupSourceName(g); name {
+	case SourceRandomWalk:
+		src = NewRandomWalkSource(profile, rng)
+	case SourceUniform:
+		src = NewUniformSource(profile, rng)
+	case SourceDataset:
+		d := c.Datasets[g.Dataset]
+		ds, err := loadDatasetCached(d.File)
+		if err != nil {
+			return nil, err
+		}
+		offset := time.Duration(d.Stagger) * time.Duration(deviceID-g.FirstID)
+		src = NewDatasetSource(ds, d.Loop, offset)
+	default:
+		return nil, fmt.Errorf("fuente de lecturas desconocida %q", name)
+	}
+	src = WithScenario(src, c.Scenario, deviceID, start, rng)
+	return WithSensorFaults(src, c.SensorFaults, deviceID, start, newStream(seed, sensorFaultBase+uint64(deviceID))), nil
+}
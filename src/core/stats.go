@@ -0,0 +1,89 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats resume el progreso de la simulación
+type Stats struct {
+	Started   time.Time
+	Seed      int64     // semilla de la ejecución
+	SimStart  time.Time // hora simulada inicial; con Seed permite repetir la ejecución
+	SimTime   time.Time // hora del reloj de la simulación
+	Devices   int
+	Published uint64
+	Failed    uint64 // mensajes descartados tras agotar los reintentos
+	Retries   uint64
+	Buffered  int    // mensajes esperando reconexión
+	Dropped   uint64 // descartados por desbordar el buffer offline
+
+	// sólo con broker.per_device_clients
+	Connections     int    // clientes de device conectados
+	ConnectFailures uint64 // intentos de conexión fallidos
+	ConnectionsLost uint64 // conexiones establecidas que se cayeron
+
+	HTTPStatus map[int]uint64    // respuestas del sink http por código; 0 = error de red
+	Faults     map[string]uint64 // fallos inyectados por tipo
+}
+
+var (
+	statsMu       sync.Mutex
+	statsStarted  time.Time
+	statsSimStart time.Time
+	statsDevices  int
+
+	publishedCount atomic.Uint64
+	failedCount    atomic.Uint64
+	retriedCount   atomic.Uint64
+)
+
+// resetStats pone a cero los contadores al iniciar una simulación
+func resetStats(devices int, simStart time.Time) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statsStarted = time.Now()
+	statsSimStart = simStart
+	statsDevices = devices
+	publishedCount.Store(0)
+	failedCount.Store(0)
+	retriedCount.Store(0)
+	resetHTTPStatus()
+	resetFaultCounts()
+}
+
+// GetStats devuelve una foto de los contadores actuales
+func GetStats() Stats {
+	queued, dropped := offlineTotals()
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	return Stats{
+		Started:   statsStarted,
+		Seed:      RunSeed(),
+		SimStart:  statsSimStart,
+		SimTime:   SimClock().Now(),
+		Devices:   statsDevices,
+		Published: publishedCount.Load(),
+		Failed:    failedCount.Load(),
+		Retries:   retriedCount.Load(),
+		Buffered:  queued,
+		Dropped:   dropped,
+
+		Connections:     connectedDevices(),
+		ConnectFailures: connectFailures.Load(),
+		ConnectionsLost: connectionsLost.Load(),
+
+		HTTPStatus: HTTPStatusCounts(),
+		Faults:     FaultCounts(),
+	}
+}
+
+// Rate devuelve los mensajes por segundo desde el inicio
+func (s Stats) Rate() float64 {
+	elapsed := time.Since(s.Started).Seconds()
+	if s.Started.IsZero() || elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Published) / elapsed
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"simulator/src/models"
+)
+
+// TLSConfig configura TLS y TLS mutuo hacia el broker (URLs ssl://, mqtts:// o wss://)
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`   // bundle PEM de CAs; vacío = las del sistema
+	CertFile           string `json:"cert_file"` // certificado de cliente; admite {device_id} y {user_id} (0 en la conexión general)
+	KeyFile            string `json:"key_file"`  // clave del certificado; admite {device_id} y {user_id}
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // sólo para pruebas
+}
+
+// esquemas de URL de broker admitidos y si van cifrados
+var brokerSchemes = map[string]bool{
+	"tcp":   false,
+	"mqtt":  false,
+	"ws":    false,
+	"ssl":   true,
+	"tls":   true,
+	"mqtts": true,
+	"wss":   true,
+}
+
+// brokerURL completa con tcp:// un host sin esquema (host:puerto)
+func brokerURL(host string) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return "tcp://" + host
+}
+
+// brokerScheme devuelve el esquema de la URL del broker
+func brokerScheme(host string) string {
+	scheme, _, _ := strings.Cut(brokerURL(host), "://")
+	return strings.ToLower(scheme)
+}
+
+// perDevice indica si el certificado de cliente cambia con cada device
+func (t TLSConfig) perDevice() bool {
+	return strings.Contains(t.CertFile, "{") || strings.Contains(t.KeyFile, "{")
+}
+
+// validate revisa la sección tls de la conexión MQTT
+func (t TLSConfig) validate(host string) []error {
+	var errs []error
+	if secure, ok := brokerSchemes[brokerScheme(host)]; !ok {
+		errs = append(errs, fmt.Errorf("broker.host %q: esquema no válido, use tcp://, ssl://, mqtts://, ws:// o wss://", host))
+	} else if !secure && t != (TLSConfig{}) {
+		errs = append(errs, fmt.Errorf("broker.tls sólo se usa con URLs ssl://, mqtts:// o wss:// (host %q)", host))
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("broker.tls: cert_file y key_file deben indicarse juntos"))
+	}
+	for field, path := range map[string]string{"broker.tls.cert_file": t.CertFile, "broker.tls.key_file": t.KeyFile} {
+		if err := validateTemplate(field, path, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// loadTLS arma la configuración TLS de la conexión general o, con msg, la de un device.
+// Con certificados por device la conexión general usa el del device 0 si existe, y si no
+// se abre sin certificado de cliente.
+func loadTLS(t TLSConfig, msg *models.Message) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo leer el bundle de CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s no contiene certificados PEM válidos", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		device := msg
+		if device == nil {
+			device = &models.Message{}
+		}
+		certFile, keyFile := renderTemplate(t.CertFile, device), renderTemplate(t.KeyFile, device)
+		if msg == nil && t.perDevice() {
+			if _, err := os.Stat(certFile); err != nil {
+				return cfg, nil
+			}
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo cargar el certificado de cliente %s: %w", certFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
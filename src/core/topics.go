@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simulator/src/models"
+)
+
+// variables admitidas en tópicos y routing keys
+var templateVars = map[string]bool{
+	"{device_id}": true,
+	"{user_id}":   true,
+	"{sensor}":    true,
+}
+
+var templateVarRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+// sensores publicados por separado cuando topics.per_sensor está activo
+var sensorNames = []string{"bpm", "bpm2", "spo2", "temperature", "motion"}
+
+// renderTemplate sustituye {device_id} y {user_id} con los datos del mensaje
+func renderTemplate(tmpl string, msg *models.Message) string {
+	if !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	return strings.NewReplacer(
+		"{device_id}", strconv.Itoa(msg.DeviceId),
+		"{user_id}", strconv.Itoa(msg.UserID),
+	).Replace(tmpl)
+}
+
+// sensorTopic arma el tópico de un sensor: sustituye {sensor} o lo añade como último nivel
+func sensorTopic(tmpl, sensor string, msg *models.Message) string {
+	if strings.Contains(tmpl, "{sensor}") {
+		return renderTemplate(strings.ReplaceAll(tmpl, "{sensor}", sensor), msg)
+	}
+	return renderTemplate(tmpl, msg) + "/" + sensor
+}
+
+// DataFilter devuelve el filtro MQTT que recibe un mensaje por cada uno publicado: los
+// niveles con variables pasan a +, y con per_sensor sólo cuenta el último sensor, que
+// se publica una vez por mensaje
+func (t TopicsConfig) DataFilter() string {
+	tmpl := t.Publish
+	if t.PerSensor {
+		last := sensorNames[len(sensorNames)-1]
+		if strings.Contains(tmpl, "{sensor}") {
+			tmpl = strings.ReplaceAll(tmpl, "{sensor}", last)
+		} else {
+			tmpl += "/" + last
+		}
+	}
+	levels := strings.Split(tmpl, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "{") {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// validateTemplate comprueba que solo se usen variables conocidas
+func validateTemplate(field, tmpl string, allowSensor bool) error {
+	for _, v := range templateVarRe.FindAllString(tmpl, -1) {
+		if !templateVars[v] || (v == "{sensor}" && !allowSensor) {
+			return fmt.Errorf("%s: variable %s no válida en %q", field, v, tmpl)
+		}
+	}
+	return nil
+}
+
+// validatePublishTopic revisa una plantilla de tópico MQTT de publicación
+func validatePublishTopic(field, tmpl string, perSensor bool) error {
+	if strings.ContainsAny(tmpl, "+#") {
+		return fmt.Errorf("%s: un tópico de publicación no puede contener comodines (%q)", field, tmpl)
+	}
+	return validateTemplate(field, tmpl, perSensor)
+}
@@ -0,0 +1,20 @@
+package core
+
+import "testing"
+
+func TestDataFilter(t *testing.T) {
+	cases := []struct {
+		topics TopicsConfig
+		want   string
+	}{
+		{TopicsConfig{Publish: "esp32.datos"}, "esp32.datos"},
+		{TopicsConfig{Publish: "usuarios/{user_id}/devices/{device_id}"}, "usuarios/+/devices/+"},
+		{TopicsConfig{Publish: "sensores/{device_id}", PerSensor: true}, "sensores/+/motion"},
+		{TopicsConfig{Publish: "{sensor}/{device_id}", PerSensor: true}, "motion/+"},
+	}
+	for _, c := range cases {
+		if got := c.topics.DataFilter(); got != c.want {
+			t.Errorf("%q: filtro %q, se esperaba %q", c.topics.Publish, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WebSocketConfig ajusta las conexiones MQTT sobre WebSockets (URLs ws:// y wss://)
+type WebSocketConfig struct {
+	Path    string            `json:"path"`    // ruta del endpoint si la URL no trae una
+	Headers map[string]string `json:"headers"` // cabeceras HTTP del upgrade, p. ej. Authorization
+}
+
+// isWebSocket indica si el broker se alcanza por WebSockets
+func isWebSocket(host string) bool {
+	scheme := brokerScheme(host)
+	return scheme == "ws" || scheme == "wss"
+}
+
+// brokerAddress devuelve la URL completa del broker, con la ruta WebSocket si la URL no la incluye
+func brokerAddress(cfg BrokerConfig) string {
+	url := brokerURL(cfg.Host)
+	if !isWebSocket(cfg.Host) {
+		return url
+	}
+	_, rest, _ := strings.Cut(url, "://")
+	if !strings.Contains(rest, "/") {
+		url += cfg.WebSocket.Path
+	}
+	return url
+}
+
+// httpHeaders convierte las cabeceras configuradas al tipo que espera paho
+func (w WebSocketConfig) httpHeaders() http.Header {
+	h := make(http.Header, len(w.Headers))
+	for k, v := range w.Headers {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// validate revisa la sección websocket de la conexión MQTT
+func (w WebSocketConfig) validate(host string) []error {
+	var errs []error
+	if !strings.HasPrefix(w.Path, "/") {
+		errs = append(errs, fmt.Errorf("broker.websocket.path %q debe empezar por /", w.Path))
+	}
+	if len(w.Headers) > 0 && !isWebSocket(host) {
+		errs = append(errs, fmt.Errorf("broker.websocket.headers sólo se usan con URLs ws:// o wss:// (host %q)", host))
+	}
+	return errs
+}
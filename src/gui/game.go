@@ -55,12 +55,15 @@ type Particle struct {
 
 // Game contiene todo el estado del UI
 type Game struct {
+	cfg           *core.Config
 	fingers       []Finger
 	particles     []Particle
 	time          float64
 	lastEmit      float64
 	dataCounters  map[string]int
 	sendEvents    <-chan int
+	connEvents    <-chan core.ConnState
+	connState     core.ConnState
 	simulating    bool
 	cloudX        float32
 	cloudY        float32
@@ -70,13 +73,15 @@ type Game struct {
 	buttonStopY   float32
 	buttonW       float32
 	buttonH       float32
+	rng           *rand.Rand
 }
 
 // NewGame crea e inicializa el Game con posiciones y colores
-func NewGame() *Game {
-	rand.Seed(time.Now().UnixNano())
-
+func NewGame(cfg *core.Config) *Game {
 	g := &Game{
+		cfg:          cfg,
+		// las partículas son sólo visuales: no usan la semilla de la simulación
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
 		dataCounters: make(map[string]int),
 		cloudX:       float32(screenWidth) / 2,
 		cloudY:       80,
@@ -119,8 +124,8 @@ func (g *Game) Update() error {
 		x, y := ebiten.CursorPosition()
 		if pointInRectInt(x, y, int(g.buttonStartX), int(g.buttonStartY), int(g.buttonW), int(g.buttonH)) {
 			if !core.IsSimulationActive() {
-				// Iniciar simulación con valor por defecto (puedes cambiarlo)
-				err := core.StartSimulation(1*time.Second)
+				// Iniciar simulación con la configuración cargada al arrancar
+				err := core.StartSimulation(g.cfg)
 				if err != nil {
 					fmt.Println("No se pudo iniciar simulación:", err)
 				} else {
@@ -144,8 +149,9 @@ func (g *Game) Update() error {
 	// actualizar estado de simulación (por si se detiene desde otro sitio)
 	g.simulating = core.IsSimulationActive()
 
-	// Procesar eventos del core (envío exitoso de mensajes)
+	// Procesar eventos del core (envío exitoso de mensajes y estado del broker)
 	g.readSendEvents()
+	g.readConnEvents()
 
 	// Emitir partículas SOLO si la simulación está activa
 	if g.simulating && g.time-g.lastEmit > 0.05 {
@@ -188,6 +194,23 @@ func (g *Game) readSendEvents() {
 	}
 }
 
+// readConnEvents consume los cambios de estado de la conexión sin bloquear el frame loop
+func (g *Game) readConnEvents() {
+	if g.connEvents == nil {
+		g.connEvents = core.GetConnEvents()
+		g.connState = core.ConnectionState()
+		return
+	}
+	for {
+		select {
+		case state := <-g.connEvents:
+			g.connState = state
+		default:
+			return
+		}
+	}
+}
+
 // handleDeviceSent mapea deviceID a dedo y emite partículas visuales para reforzar el envío
 func (g *Game) handleDeviceSent(deviceID int) {
 	if len(g.fingers) == 0 {
@@ -212,18 +235,18 @@ func (g *Game) emitMultipleFromFinger(finger Finger, n int) {
 
 // emitParticle crea y añade una partícula con velocidad hacia arriba (hacia la nube)
 func (g *Game) emitParticle(finger Finger) {
-	angle := -math.Pi/2 + (rand.Float64()-0.5)*0.4
-	speed := 2.0 + rand.Float64()*2.0
+	angle := -math.Pi/2 + (g.rng.Float64()-0.5)*0.4
+	speed := 2.0 + g.rng.Float64()*2.0
 
 	p := Particle{
 		X:          finger.X,
 		Y:          finger.Y,
 		VX:         math.Cos(angle) * speed,
 		VY:         math.Sin(angle) * speed,
-		Life:       2.0 + rand.Float64(),
-		MaxLife:    2.0 + rand.Float64(),
+		Life:       2.0 + g.rng.Float64(),
+		MaxLife:    2.0 + g.rng.Float64(),
 		Color:      finger.Color,
-		Size:       3 + rand.Float64()*2,
+		Size:       3 + g.rng.Float64()*2,
 		FingerName: finger.Name,
 	}
 
@@ -337,6 +360,22 @@ func (g *Game) drawInfoPanel(screen *ebiten.Image) {
 	}
 	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("TOTAL: %d pkts", total),
 		int(panelX)+10, int(panelY+panelH)-24)
+
+	// estado del broker y mensajes esperando reconexión
+	stats := core.GetStats()
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("BROKER: %s", g.connState),
+		int(panelX)+10, int(panelY)+34)
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("EN COLA: %d", stats.Buffered),
+		int(panelX)+10, int(panelY)+54)
+	if g.cfg.Broker.PerDeviceClients {
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("CONEXIONES: %d/%d (fallos %d)",
+			stats.Connections, stats.Devices, stats.ConnectFailures+stats.ConnectionsLost),
+			int(panelX)+10, int(panelY)+74)
+	}
+
+	// mensajes que el broker no aceptó tras los reintentos
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("FALLIDOS: %d (reintentos %d)", stats.Failed, stats.Retries),
+		int(panelX)+10, int(panelY+panelH)-44)
 }
 
 // drawButton dibuja un rectángulo y texto para un botón
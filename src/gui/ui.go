@@ -1,6 +1,8 @@
 package gui
 
+import "simulator/src/core"
+
 // Punto de inicio de la interfaz
-func StartUI() *Game {
-	return NewGame()
+func StartUI(cfg *core.Config) *Game {
+	return NewGame(cfg)
 }
@@ -0,0 +1,146 @@
+package headless
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"simulator/src/core"
+)
+
+// tiempo máximo para vaciar el pipeline al detener
+const drainTimeout = 5 * time.Second
+
+// Run ejecuta la simulación sin ventana hasta que se cumple su duración o llega SIGINT/SIGTERM
+func Run(cfg *core.Config, statsEvery time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.UsesSink(core.SinkMQTT) {
+		if err := core.ConnectMqtt(cfg); err != nil {
+			return err
+		}
+		defer core.DisconnectMqtt()
+	}
+
+	if err := core.StartSimulation(cfg); err != nil {
+		return err
+	}
+	done := core.SimulationDone()
+	if cfg.Replay.File != "" {
+		fmt.Printf("Reproducción headless iniciada: %s a %gx, %d dispositivos\n",
+			cfg.Replay.File, cfg.Replay.Speed, core.GetStats().Devices)
+	} else {
+		fmt.Printf("Simulación headless iniciada: %d dispositivos, duración %s\n",
+			cfg.DeviceCount(), durationLabel(cfg.Duration))
+	}
+
+	ticker := time.NewTicker(statsEvery)
+	defer ticker.Stop()
+
+	var last uint64
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Señal recibida, deteniendo simulación")
+			core.StopSimulation()
+			running = false
+		case <-done:
+			running = false
+		case <-ticker.C:
+			s := core.GetStats()
+			fmt.Printf("[stats] %s broker=%s publicados=%d (+%d) %.1f msg/s fallidos=%d reintentos=%d en_cola=%d descartados=%d\n",
+				time.Since(s.Started).Truncate(time.Second), core.ConnectionState(), s.Published, s.Published-last,
+				float64(s.Published-last)/statsEvery.Seconds(), s.Failed, s.Retries, s.Buffered, s.Dropped)
+			if cfg.Clock.Mode == core.ClockScaled || cfg.Clock.Mode == core.ClockManual {
+				fmt.Printf("[stats] reloj %s\n", s.SimTime.Format(time.RFC3339))
+			}
+			if cfg.UsesSink(core.SinkHTTP) {
+				fmt.Printf("[stats] http %s\n", formatHTTPStatus(s.HTTPStatus))
+			}
+			if cfg.Faults.Enabled() {
+				fmt.Printf("[stats] fallos %s\n", core.FormatFaults(s.Faults))
+			}
+			if cfg.Broker.PerDeviceClients {
+				fmt.Printf("[stats] conexiones=%d/%d fallos_conexion=%d caidas=%d\n",
+					s.Connections, s.Devices, s.ConnectFailures, s.ConnectionsLost)
+			}
+			last = s.Published
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		fmt.Println("El pipeline no terminó de vaciarse a tiempo")
+	}
+
+	s := core.GetStats()
+	fmt.Printf("Simulación finalizada: %d mensajes en %s (%.1f msg/s), %d fallidos, %d sin enviar\n",
+		s.Published, time.Since(s.Started).Truncate(time.Second), s.Rate(), s.Failed, s.Buffered)
+	if cfg.Replay.File == "" {
+		start := s.SimStart.Format(time.RFC3339)
+		fmt.Printf("Semilla: %d, inicio %s (repetir con -seed %d -start %s)\n", s.Seed, start, s.Seed, start)
+	}
+	if cfg.UsesSink(core.SinkHTTP) {
+		fmt.Printf("Respuestas HTTP: %s\n", formatHTTPStatus(s.HTTPStatus))
+	}
+	if cfg.Faults.Enabled() {
+		fmt.Printf("Fallos inyectados: %s\n", core.FormatFaults(s.Faults))
+	}
+	if cfg.Broker.PerDeviceClients {
+		printConnFailures(s)
+	}
+	return nil
+}
+
+// formatHTTPStatus lista las respuestas por código en orden ("200=120 503=4 red=1")
+func formatHTTPStatus(counts map[int]uint64) string {
+	if len(counts) == 0 {
+		return "sin respuestas"
+	}
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		label := strconv.Itoa(code)
+		if code == 0 {
+			label = "red"
+		}
+		parts[i] = fmt.Sprintf("%s=%d", label, counts[code])
+	}
+	return strings.Join(parts, " ")
+}
+
+// cuántos devices con fallos de conexión se listan en el resumen
+const maxFailureLines = 10
+
+// printConnFailures resume los fallos de conexión por device, los peores primero
+func printConnFailures(s core.Stats) {
+	failures := core.ConnFailures()
+	fmt.Printf("Conexiones: %d fallos de conexión y %d caídas en %d de %d devices\n",
+		s.ConnectFailures, s.ConnectionsLost, len(failures), s.Devices)
+	for i, f := range failures {
+		if i == maxFailureLines {
+			fmt.Printf("  ... y %d devices más\n", len(failures)-maxFailureLines)
+			break
+		}
+		fmt.Printf("  device %d: %d fallos, %d caídas, último error: %s\n", f.DeviceID, f.Failures, f.Lost, f.LastError)
+	}
+}
+
+func durationLabel(d core.Duration) string {
+	if d <= 0 {
+		return "indefinida"
+	}
+	return time.Duration(d).String()
+}
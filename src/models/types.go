@@ -13,4 +13,14 @@ type Message struct {
 	Bpm2     int `json:"bpm2"`
 	Moving   bool  `json:"moving"`
 	Temperature float64 `json:"temperature"`
+
+	// sólo con payload versión 2
+	Timestamp any    `json:"timestamp,omitempty"` // RFC3339 (string) o epoch en ms (número)
+	Seq       uint64 `json:"seq,omitempty"`       // creciente por device, para detectar huecos y desorden
+	MessageID string `json:"message_id,omitempty"` // UUID, para detectar duplicados
+
+	// payload ya serializado que se publica en lugar de los campos (fallos inyectados)
+	Raw []byte `json:"-"`
+	// fallos inyectados en el mensaje, separados por comas; sólo para la captura
+	Fault string `json:"-"`
 }
\ No newline at end of file